@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
+)
+
+const usage = `Usage: extractiontest [flags]
+
+Runs sample events through the extraction stages offline and compares the fields with the expected ones.
+
+The config directory holds <sourcetype>.json files as kept by regexuploader sync. The tests directory holds
+<sourcetype>.json files with one test case or an array of them, a test case is an event as sent to
+fieldextractor2 with the expected fields:
+
+  {"sourcetype": "cisco:asa", "event": "...", "fields": {"src_ip": "10.0.0.1", "proto": ["6", "17"]}}
+
+Flags:
+`
+
+// TestCase Struct, the file a case was read from and its position there
+type TestCase struct {
+	File  string
+	Index int
+
+	Raw      map[string]json.RawMessage
+	LogEvent pipeline.LogEvent
+	Expected map[string]interface{}
+}
+
+// Difference Struct, one field differing from the expected output
+type Difference struct {
+	Kind     string
+	Field    string
+	Expected interface{}
+	Actual   interface{}
+}
+
+func main() {
+
+	flags := flag.NewFlagSet("extractiontest", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	configDir := flags.String("config", "conf", "directory of <sourcetype>.json extraction configs")
+	testsDir := flags.String("tests", "tests", "directory of <sourcetype>.json test cases")
+	stages := flags.String("stages", "route,extract,calc,normalize", "stages to run, comma separated")
+	update := flags.Bool("update", false, "write the fields produced as expected fields")
+	verbose := flags.Bool("v", false, "print passing test cases too")
+
+	flags.Parse(os.Args[1:])
+
+	failed, err := run(*configDir, *testsDir, *stages, *update, *verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Function to run all test cases, returns the number of failed cases
+func run(configDir string, testsDir string, stages string, update bool, verbose bool) (int, error) {
+
+	logger := pipeline.StdLogger{Verbose: verbose}
+
+	source, err := pipeline.NewDirConfigSource(configDir)
+	if err != nil {
+		return 0, err
+	}
+
+	pipelineConfig := &pipeline.PipelineConfig{Version: pipeline.PipelineConfigVersion, Name: "extractiontest"}
+
+	for _, stage := range strings.Split(stages, ",") {
+		stage = strings.TrimSpace(stage)
+		if !pipeline.IsStageType(stage) {
+			return 0, fmt.Errorf("unknown stage %q", stage)
+		}
+		pipelineConfig.Stages = append(pipelineConfig.Stages, pipeline.StageConfig{Type: stage})
+	}
+
+	config, err := pipeline.LoadConfigFromSource(source, logger)
+	if err != nil {
+		return 0, err
+	}
+
+	testPipeline, err := pipelineConfig.Build(config, logger)
+	if err != nil {
+		return 0, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(testsDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(paths) == 0 {
+		return 0, fmt.Errorf("%s: no <sourcetype>.json test cases found", testsDir)
+	}
+
+	passed, failed := 0, 0
+
+	for _, path := range paths {
+
+		testCases, err := readTestCases(path)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, testCase := range testCases {
+
+			actual, err := runTestCase(testPipeline, testCase, logger)
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL %s #%d: %v\n", testCase.File, testCase.Index+1, err)
+				continue
+			}
+
+			differences := compareFields(testCase.Expected, actual)
+
+			if update {
+				testCase.Expected = actual
+			}
+
+			if len(differences) == 0 {
+				passed++
+				if verbose {
+					fmt.Printf("PASS %s #%d\n", testCase.File, testCase.Index+1)
+				}
+				continue
+			}
+
+			failed++
+			fmt.Printf("FAIL %s #%d: %s\n", testCase.File, testCase.Index+1, shorten(testCase.LogEvent.Event, 80))
+			for _, difference := range differences {
+				switch difference.Kind {
+				case "missing":
+					fmt.Printf("  missing    %s: %s\n", difference.Field, formatValue(difference.Expected))
+				case "unexpected":
+					fmt.Printf("  unexpected %s: %s\n", difference.Field, formatValue(difference.Actual))
+				case "changed":
+					fmt.Printf("  changed    %s: %s -> %s\n", difference.Field, formatValue(difference.Expected), formatValue(difference.Actual))
+				}
+			}
+		}
+
+		if update {
+			if err := writeTestCases(path, testCases); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+
+	if update && failed > 0 {
+		fmt.Printf("expected fields of %d test cases updated\n", failed)
+		return 0, nil
+	}
+
+	return failed, nil
+}
+
+// Function to read one test case or an array of them, the sourcetype defaults to the file name
+func readTestCases(path string) ([]*TestCase, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raws []map[string]json.RawMessage
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &raws)
+	} else {
+		var raw map[string]json.RawMessage
+		err = json.Unmarshal(data, &raw)
+		raws = append(raws, raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	sourcetype, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var testCases []*TestCase
+
+	for i, raw := range raws {
+
+		testCase := &TestCase{File: filepath.Base(path), Index: i, Raw: raw, Expected: map[string]interface{}{}}
+
+		event, _ := json.Marshal(raw)
+
+		// Fields sent along are discarded, they are the expected output
+		if testCase.LogEvent, err = pipeline.ParseLogEvent(event); err != nil {
+			return nil, fmt.Errorf("%s #%d: %v", path, i+1, err)
+		}
+
+		if fields, ok := raw["fields"]; ok {
+			if err := json.Unmarshal(fields, &testCase.Expected); err != nil {
+				return nil, fmt.Errorf("%s #%d: fields: %v", path, i+1, err)
+			}
+		}
+
+		if testCase.LogEvent.Sourcetype == "" {
+			testCase.LogEvent.Sourcetype = sourcetype
+		}
+
+		testCases = append(testCases, testCase)
+	}
+
+	return testCases, nil
+}
+
+// Function to write test cases back with their expected fields
+func writeTestCases(path string, testCases []*TestCase) error {
+
+	var raws []map[string]json.RawMessage
+
+	for _, testCase := range testCases {
+		fields, _ := json.Marshal(testCase.Expected)
+		testCase.Raw["fields"] = fields
+		raws = append(raws, testCase.Raw)
+	}
+
+	var value interface{} = raws
+	if len(raws) == 1 {
+		value = raws[0]
+	}
+
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Function to run a test case, returns the fields of the event, multi value fields as lists
+func runTestCase(testPipeline *pipeline.Pipeline, testCase *TestCase, logger pipeline.Logger) (map[string]interface{}, error) {
+
+	logEvent := testCase.LogEvent
+	logEvent.Fields = map[string]string{}
+	logEvent.MultiFields = map[string][]string{}
+
+	logEvents, err := testPipeline.Process(logEvent, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(logEvents) == 0 {
+		return nil, fmt.Errorf("event dropped")
+	}
+
+	fields := map[string]interface{}{}
+
+	for key, value := range logEvents[0].Fields {
+		fields[key] = value
+	}
+
+	for key, values := range logEvents[0].MultiFields {
+		list := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			list = append(list, value)
+		}
+		fields[key] = list
+	}
+
+	return fields, nil
+}
+
+// Function to list missing, unexpected and changed fields
+func compareFields(expected map[string]interface{}, actual map[string]interface{}) []Difference {
+
+	var differences []Difference
+
+	for field, value := range expected {
+		actualValue, ok := actual[field]
+		if !ok {
+			differences = append(differences, Difference{Kind: "missing", Field: field, Expected: value})
+		} else if !reflect.DeepEqual(value, actualValue) {
+			differences = append(differences, Difference{Kind: "changed", Field: field, Expected: value, Actual: actualValue})
+		}
+	}
+
+	for field, value := range actual {
+		if _, ok := expected[field]; !ok {
+			differences = append(differences, Difference{Kind: "unexpected", Field: field, Actual: value})
+		}
+	}
+
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Field < differences[j].Field
+	})
+
+	return differences
+}
+
+// Function to format a field value as JSON
+func formatValue(value interface{}) string {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
+
+// Function to shorten an event for the report
+func shorten(event string, length int) string {
+
+	if len(event) <= length {
+		return event
+	}
+
+	return event[:length] + "..."
+}
@@ -2,15 +2,50 @@ package main
 
 import (
 	"encoding/json"
+	"os"
 	"regexp"
 
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
 	"github.com/nuclio/nuclio-sdk-go"
 	"github.com/nuclio/nuclio-test-go"
+	"github.com/v3io/v3io-go-http"
 )
 
+// Stored sourcetype configs are read from, nil if neither CONFIG_SOURCE nor a db0 data binding is set
+var configSource pipeline.ConfigSource
+
+// InitContext for setting up function
+func InitContext(context *nuclio.Context) error {
+
+	container, _ := context.DataBinding["db0"].(*v3io.Container)
+
+	if configSource != nil || (container == nil && os.Getenv("CONFIG_SOURCE") == "") {
+		return nil
+	}
+
+	source, err := pipeline.OpenConfigSource(os.Getenv("CONFIG_SOURCE"), container)
+	if err != nil {
+		context.Logger.ErrorWith("Config source error", "err", err)
+		return err
+	}
+
+	configSource = source
+
+	return nil
+}
+
 // Handler for HTTP Triggers
 func Handler(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
 
+	if event.GetPath() == "/generate" {
+		return handleGenerate(context, event)
+	}
+
+	// Requests without X-Regex header are playground requests
+	if event.GetHeader("X-Regex") == nil {
+		return handlePlayground(context, event)
+	}
+
 	body := string(event.GetBody())
 
 	if len(body) == 0 {
@@ -46,7 +81,7 @@ func Handler(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
 		}, nil
 	}
 
-	fields := reSubMatchMap(r, body)
+	fields := pipeline.DoRegexMatch(r, body)
 
 	if fields != nil {
 		// Format into JSON
@@ -66,21 +101,77 @@ func Handler(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
 	}, nil
 }
 
-func reSubMatchMap(r *regexp.Regexp, str string) map[string]string {
+// Function to handle a JSON playground request, see PlaygroundRequest
+func handlePlayground(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
 
-	match := r.FindStringSubmatch(str)
+	var request PlaygroundRequest
 
-	if match != nil {
-		subMatchMap := make(map[string]string)
-		for i, name := range r.SubexpNames() {
-			if i != 0 {
-				subMatchMap[name] = match[i]
-			}
-		}
-		return subMatchMap
+	if err := json.Unmarshal(event.GetBody(), &request); err != nil {
+		return nuclio.Response{
+			StatusCode:  400,
+			ContentType: "application/text",
+			Body:        []byte("Request error: " + err.Error()),
+		}, nil
+	}
 
+	// Get Splunk Field Prefixer setting from header (normal, prefix)
+	prefixFields := false
+	switch value := event.GetHeader("Field-Prefix-Mode").(type) {
+	case []byte:
+		prefixFields = string(value) == "prefix"
+	case string:
+		prefixFields = value == "prefix"
 	}
-	return nil
+
+	response, err := runPlayground(request, configSource, prefixFields, context.Logger)
+	if err != nil {
+		return nuclio.Response{
+			StatusCode:  400,
+			ContentType: "application/text",
+			Body:        []byte(err.Error()),
+		}, nil
+	}
+
+	responseJSON, _ := json.Marshal(response)
+
+	return nuclio.Response{
+		StatusCode:  200,
+		ContentType: "application/json",
+		Body:        responseJSON,
+	}, nil
+}
+
+// Function to handle a JSON regex generation request, see GenerateRequest
+func handleGenerate(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
+
+	var request GenerateRequest
+
+	if err := json.Unmarshal(event.GetBody(), &request); err != nil {
+		return nuclio.Response{
+			StatusCode:  400,
+			ContentType: "application/text",
+			Body:        []byte("Request error: " + err.Error()),
+		}, nil
+	}
+
+	response, err := runGenerate(request)
+	if err != nil {
+		return nuclio.Response{
+			StatusCode:  400,
+			ContentType: "application/text",
+			Body:        []byte(err.Error()),
+		}, nil
+	}
+
+	context.Logger.DebugWith("Generated regex", "regex", response.Regex, "valid", response.Valid)
+
+	responseJSON, _ := json.Marshal(response)
+
+	return nuclio.Response{
+		StatusCode:  200,
+		ContentType: "application/json",
+		Body:        responseJSON,
+	}, nil
 }
 
 func main() {
@@ -90,6 +181,11 @@ func main() {
 		panic(err)
 	}
 
+	// Stored sourcetype configs are read from CONFIG_SOURCE if set
+	if err = tc.InitContext(InitContext); err != nil {
+		panic(err)
+	}
+
 	// Create a new test event
 	testEvent := nutest.TestEvent{
 		Path:    "/",
@@ -105,4 +201,20 @@ func main() {
 
 	// Log results
 	tc.Logger.InfoWith("Run complete", "Body", responseBody, "err", err)
+
+	// Create a playground event with two patterns
+	playgroundEvent := nutest.TestEvent{
+		Path: "/",
+		Body: []byte(`{
+			"text": "Name=Kent Firstname=Clark\nName=Wayne Firstname=Bruce",
+			"patterns": [
+				{"name": "name", "regex": "Name=(?P<name>\\w+)"},
+				{"name": "firstname", "regex": "Firstname=(?P<firstname>\\w+)"}
+			]
+		}`),
+	}
+
+	resp, err = tc.Invoke(&playgroundEvent)
+
+	tc.Logger.InfoWith("Playground complete", "Body", string(resp.(nuclio.Response).Body), "err", err)
 }
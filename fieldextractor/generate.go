@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/my2ndhead/nuclio_event_etl/pipeline/regexgen"
+)
+
+// GenerateRequest Struct, examples with marked fields the regex is generated from, the others it is validated against
+type GenerateRequest struct {
+	Sourcetype string             `json:"sourcetype"`
+	Class      string             `json:"class"`
+	Examples   []regexgen.Example `json:"examples"`
+}
+
+// GenerateResponse Struct, Command saves the regex with regexuploader once it is valid
+type GenerateResponse struct {
+	Regex   string            `json:"regex"`
+	Valid   bool              `json:"valid"`
+	Results []regexgen.Result `json:"results"`
+	Command string            `json:"command,omitempty"`
+}
+
+// Function to propose a regex for the marked fields and validate it against all examples
+func runGenerate(request GenerateRequest) (*GenerateResponse, error) {
+
+	regex, err := regexgen.Generate(request.Examples)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := regexgen.Validate(regex, request.Examples)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &GenerateResponse{Regex: regex, Valid: true, Results: results}
+
+	for _, result := range results {
+		if !result.OK {
+			response.Valid = false
+		}
+	}
+
+	if request.Sourcetype != "" && response.Valid {
+		// Classes are named after their capture groups by default, like regexuploader import does
+		class := request.Class
+		if class == "" {
+			class = strings.Join(regexp.MustCompile(regex).SubexpNames()[1:], ",")
+		}
+		response.Command = fmt.Sprintf("regexuploader put -sourcetype %s extract %s %s", shellQuote(request.Sourcetype), shellQuote(class), shellQuote("regex="+regex))
+	}
+
+	return response, nil
+}
+
+// Function to quote a shell argument in single quotes
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
+)
+
+// Matches listed per pattern and event, the extraction itself is not limited
+const maxMatchesPerEvent = 100
+
+// Event output modes fields are returned for, as set by the Event-Output-Mode header of fieldextractor2
+var outputModes = []string{"normal", "minimal", "kv", "none"}
+
+// PlaygroundRequest Struct, sample events are the lines of text or the events given
+type PlaygroundRequest struct {
+	Text     string    `json:"text"`
+	Events   []string  `json:"events"`
+	Patterns []Pattern `json:"patterns"`
+
+	// Stored config to run the events through, its extract classes are reported like patterns
+	Sourcetype string `json:"sourcetype"`
+	Host       string `json:"host"`
+	Source     string `json:"source"`
+	Index      string `json:"index"`
+}
+
+// Pattern Struct
+type Pattern struct {
+	Name       string `json:"name"`
+	Regex      string `json:"regex"`
+	MultiMatch bool   `json:"multimatch"`
+}
+
+// PlaygroundResponse Struct
+type PlaygroundResponse struct {
+	Sourcetype string          `json:"sourcetype,omitempty"`
+	Patterns   []PatternResult `json:"patterns"`
+	Events     []EventResult   `json:"events"`
+}
+
+// PatternResult Struct, durations are the time spent matching all events
+type PatternResult struct {
+	Name          string   `json:"name"`
+	Regex         string   `json:"regex"`
+	Stored        bool     `json:"stored"`
+	Error         string   `json:"error,omitempty"`
+	Fields        []string `json:"fields"`
+	MatchedEvents int      `json:"matched_events"`
+	DurationNs    int64    `json:"duration_ns"`
+	MaxDurationNs int64    `json:"max_duration_ns"`
+	Matches       []Match  `json:"matches"`
+}
+
+// Match Struct, offsets are byte offsets into the event, the end is exclusive
+type Match struct {
+	Event  int          `json:"event"`
+	Start  int          `json:"start"`
+	End    int          `json:"end"`
+	Groups []GroupMatch `json:"groups"`
+}
+
+// GroupMatch Struct, unnamed groups are named by their number
+type GroupMatch struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// EventResult Struct, the HEC event fieldextractor2 would send by Event-Output-Mode
+type EventResult struct {
+	Event   string                       `json:"event"`
+	Dropped bool                         `json:"dropped"`
+	Outputs map[string]pipeline.HECEvent `json:"outputs,omitempty"`
+}
+
+// Function to run the patterns and the stored config of the sourcetype over the sample events
+func runPlayground(request PlaygroundRequest, source pipeline.ConfigSource, prefixFields bool, logger pipeline.Logger) (*PlaygroundResponse, error) {
+
+	events := request.Events
+	if len(events) == 0 {
+		for _, line := range strings.Split(request.Text, "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				events = append(events, line)
+			}
+		}
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no sample events, set text or events")
+	}
+
+	if len(request.Patterns) == 0 && request.Sourcetype == "" {
+		return nil, fmt.Errorf("no patterns and no sourcetype given")
+	}
+
+	config := &pipeline.Config{}
+
+	sourcetype := request.Sourcetype
+	if sourcetype != "" {
+		if source == nil {
+			return nil, fmt.Errorf("no config source available for sourcetype %s", sourcetype)
+		}
+		// Read for every request, so changes uploaded with regexuploader are picked up right away
+		var err error
+		if config, err = pipeline.LoadExtractionConfig(source, logger); err != nil {
+			return nil, err
+		}
+	} else {
+		sourcetype = "playground"
+	}
+
+	var patterns []Pattern
+
+	for _, regexExtract := range config.RegexExtracts {
+		if regexExtract.Sourcetype == sourcetype {
+			patterns = append(patterns, Pattern{Name: regexExtract.Class, Regex: regexExtract.Regex, MultiMatch: regexExtract.MultiMatch})
+		}
+	}
+
+	// Stored extract classes come first
+	storedPatterns := len(patterns)
+
+	response := &PlaygroundResponse{Sourcetype: request.Sourcetype}
+
+	for i, pattern := range request.Patterns {
+
+		if pattern.Name == "" {
+			pattern.Name = "pattern" + strconv.Itoa(i+1)
+		}
+
+		// Patterns are tried in addition to the stored extractions
+		if _, err := regexp.Compile(pattern.Regex); err == nil {
+			config.RegexExtracts = append(config.RegexExtracts, pipeline.RegexExtract{Sourcetype: sourcetype, Class: pattern.Name, Regex: pattern.Regex, MultiMatch: pattern.MultiMatch})
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	for i, pattern := range patterns {
+		response.Patterns = append(response.Patterns, matchPattern(pattern, i < storedPatterns, events))
+	}
+
+	extractPipeline := pipeline.New(pipeline.NewRouteStage(config), pipeline.NewExtractStage(config), pipeline.NewCalcStage(config), pipeline.NewNormalizeStage(config))
+
+	for _, event := range events {
+
+		logEvent := pipeline.NewLogEvent()
+		logEvent.Event = event
+		logEvent.Sourcetype = sourcetype
+		logEvent.Host = request.Host
+		logEvent.Source = request.Source
+		logEvent.Index = request.Index
+		logEvent.PrefixFields = prefixFields
+
+		result := EventResult{Event: event}
+
+		logEvents, err := extractPipeline.Process(logEvent, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(logEvents) == 0 {
+			result.Dropped = true
+			response.Events = append(response.Events, result)
+			continue
+		}
+
+		result.Outputs = map[string]pipeline.HECEvent{}
+
+		for _, outputMode := range outputModes {
+			formatted := logEvents[0]
+			formatted.OutputMode = outputMode
+
+			formattedEvents, _ := pipeline.NewFormatStage().Process(formatted, logger)
+
+			hecEvent := pipeline.GetHECEvent(formattedEvents[0])
+			if request.Sourcetype == "" {
+				hecEvent.Sourcetype = ""
+			}
+			result.Outputs[outputMode] = hecEvent
+		}
+
+		response.Events = append(response.Events, result)
+	}
+
+	return response, nil
+}
+
+// Function to find all matches of a pattern with their offsets, timing each event
+func matchPattern(pattern Pattern, stored bool, events []string) PatternResult {
+
+	result := PatternResult{Name: pattern.Name, Regex: pattern.Regex, Stored: stored, Fields: []string{}, Matches: []Match{}}
+
+	r, err := regexp.Compile(pattern.Regex)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	names := r.SubexpNames()
+	for _, name := range names[1:] {
+		if name != "" {
+			result.Fields = append(result.Fields, name)
+		}
+	}
+
+	for i, event := range events {
+
+		start := time.Now()
+		indexes := r.FindAllStringSubmatchIndex(event, maxMatchesPerEvent)
+		duration := time.Since(start).Nanoseconds()
+
+		result.DurationNs += duration
+		if duration > result.MaxDurationNs {
+			result.MaxDurationNs = duration
+		}
+
+		if len(indexes) > 0 {
+			result.MatchedEvents++
+		}
+
+		for _, index := range indexes {
+
+			match := Match{Event: i, Start: index[0], End: index[1], Groups: []GroupMatch{}}
+
+			for group := 1; group < len(names); group++ {
+
+				// Groups not taking part in the match have no offsets
+				if index[2*group] < 0 {
+					continue
+				}
+
+				name := names[group]
+				if name == "" {
+					name = strconv.Itoa(group)
+				}
+
+				match.Groups = append(match.Groups, GroupMatch{Name: name, Value: event[index[2*group]:index[2*group+1]], Start: index[2*group], End: index[2*group+1]})
+			}
+
+			result.Matches = append(result.Matches, match)
+		}
+	}
+
+	return result
+}
@@ -3,12 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-	"regexp"
+	"os"
 
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
+	"github.com/my2ndhead/nuclio_event_etl/pipeline/hectest"
 	"github.com/nuclio/nuclio-sdk-go"
 	"github.com/nuclio/nuclio-test-go"
 	"github.com/v3io/v3io-go-http"
@@ -16,64 +16,91 @@ import (
 
 //********************************
 
-// HECConnection Struct
-type HECConnection struct {
-	URL            string `json:"url"`
-	Authentication string `json:"authentication"`
-}
-
-// RegexExtract Struct
-type RegexExtract struct {
-	Sourcetype string `json:"sourcetype"`
-	Class      string `json:"class"`
-	Regex      string `json:"regex"`
-}
-
-// LogEvent Struct
-type LogEvent struct {
-	Time       string            `json:"time"`
-	Meta       string            `json:"meta"`
-	Host       string            `json:"host"`
-	Sourcetype string            `json:"sourcetype"`
-	Source     string            `json:"source"`
-	Index      string            `json:"index"`
-	Event      string            `json:"event"`
-	Fields     map[string]string `json:"fields"`
-}
-
-// ListBucketResult Struct
-type ListBucketResult struct {
-	Name           string         `xml:"Name"`
-	Prefix         string         `xml:"Prefix"`
-	Marker         string         `xml:"Marker"`
-	Delimiter      string         `xml:"Delimiter"`
-	NextMarker     string         `xml:"NextMarker"`
-	MaxKeys        string         `xml:"NextMaxKeys"`
-	IsTruncated    string         `xml:"IsTruncated"`
-	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes"`
-}
-
-// CommonPrefix Struct
-type CommonPrefix struct {
-	Prefix string `xml:"Prefix"`
-}
-
-var regexExtracts []RegexExtract
-
-var myHECConnection HECConnection
+// Pipeline used unless PIPELINE_CONFIG names a file or v3io:<path>
+const defaultPipelineConfig = `
+version: 1
+name: fieldextractor2
+input:
+  type: http
+  eventoutputmode: normal
+  fieldprefixmode: prefix
+stages:
+  # Guessing the sourcetype of events sent without one
+  - type: classify
+  # Rewriting index, sourcetype, host and source before extraction
+  - type: route
+  # Fetching fields from event
+  - type: extract
+  - type: calc
+  - type: normalize
+  # Attributing events without host to the sender, before rules keyed on the host
+  - type: host
+  # Dropping unwanted events before enrichment and output
+  - type: filter
+  - type: dedup
+  - type: sample
+  # Enriching events
+  - type: lookup
+  - type: geoip
+  # Fetching internal fields from meta element
+  - type: meta
+  # Masking has to run last, after every stage adding fields
+  - type: redact
+  # Converting event to metrics, possibly instead of sending it
+  - type: aggregate
+  # Rewriting event according to output mode
+  - type: format
+outputs:
+  # Connection is read from /conf/outputs/hec/0
+  - type: hec
+`
+
+var fieldPipeline *pipeline.Pipeline
+
+var inputConfig pipeline.InputConfig
+
+// Config source used instead of CONFIG_SOURCE if set before InitContext, e.g. a pipeline.MemoryConfigSource
+var configSource pipeline.ConfigSource
 
 // InitContext for setting up function
 func InitContext(context *nuclio.Context) error {
 	context.UserData = fmt.Sprintf("User data initialized from context: %d", context.WorkerID)
 
-	container := context.DataBinding["db0"].(*v3io.Container)
+	// No container when running offline, the config is then read from CONFIG_SOURCE
+	container, _ := context.DataBinding["db0"].(*v3io.Container)
 
-	// Get Regex Extracts for sourceype
-	regexExtracts = getRegexExtracts(container, context)
+	pipelineConfig, err := pipeline.LoadPipelineConfig(os.Getenv("PIPELINE_CONFIG"), container, defaultPipelineConfig)
+	if err != nil {
+		context.Logger.ErrorWith("Pipeline config error", "err", err)
+		return err
+	}
+
+	inputConfig = pipelineConfig.Input
+
+	source := configSource
+	if source == nil {
+		source, err = pipeline.OpenConfigSource(os.Getenv("CONFIG_SOURCE"), container)
+		if err != nil {
+			context.Logger.ErrorWith("Config source error", "err", err)
+			return err
+		}
+	}
 
-	myHECConnection = getHTTPEventCollectorConnection(container, context)
+	// Get the complete configuration below /conf/
+	config, err := pipeline.LoadConfigFromSource(source, context.Logger)
+	if err != nil {
+		context.Logger.ErrorWith("Config error", "err", err)
+		return err
+	}
+	config.Container = container
 
-	context.Logger.Debug("myHECConnection.URL:", myHECConnection.URL)
+	context.Logger.Debug("myHECConnection.URL:", config.HECConnection.URL)
+
+	fieldPipeline, err = pipelineConfig.Build(config, context.Logger)
+	if err != nil {
+		context.Logger.ErrorWith("Pipeline build error", "err", err)
+		return err
+	}
 
 	return nil
 }
@@ -81,40 +108,19 @@ func InitContext(context *nuclio.Context) error {
 // Handler for HTTP Triggers
 func Handler(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
 
-	// Get Nuclio Event body
-	body := string(event.GetBody())
-
-	// Get Splunk Event Optimizer setting from header (normal, minimal, kv)
-	var eventOutputMode = "normal"
-
-	//context.Logger.Debug("Unmarshall LogEvent:", eventOutputMode)
-
-	if event.GetHeader("Event-Output-Mode") != nil {
-		// Header types differ between nuclio and nuclio-test invocations
-		if _, ok := event.GetHeader("Event-Output-Mode").([]byte); ok {
-			eventOutputMode = string(event.GetHeader("Event-Output-Mode").([]byte))
-		} else if _, ok := event.GetHeader("Event-Output-Mode").([]uint8); ok {
-			eventOutputMode = string(event.GetHeader("Event-Output-Mode").([]uint8))
-		} else if _, ok := event.GetHeader("Event-Output-Mode").(string); ok {
-			eventOutputMode = event.GetHeader("Event-Output-Mode").(string)
-		}
+	// Serve the metrics to Prometheus, scraping with GET on the function's HTTP trigger
+	if event.GetPath() == "/metrics" {
+		var metricsText bytes.Buffer
+		pipeline.Metrics().Write(&metricsText)
+		return nuclio.Response{
+			StatusCode:  200,
+			ContentType: "text/plain; version=0.0.4",
+			Body:        metricsText.Bytes(),
+		}, nil
 	}
 
-	// Get Splunk Field Prefixersetting from header (normal, prefix)
-	var fieldPrefixMode = "prefix"
-
-	//context.Logger.Debug("Unmarshall LogEvent:", eventOutputMode)
-
-	if event.GetHeader("Field-Prefix-Mode") != nil {
-		// Header types differ between nuclio and nuclio-test invocations
-		if _, ok := event.GetHeader("Event-Output-Mode").([]byte); ok {
-			eventOutputMode = string(event.GetHeader("Event-Output-Mode").([]byte))
-		} else if _, ok := event.GetHeader("Event-Output-Mode").([]uint8); ok {
-			eventOutputMode = string(event.GetHeader("Event-Output-Mode").([]uint8))
-		} else if _, ok := event.GetHeader("Event-Output-Mode").(string); ok {
-			eventOutputMode = event.GetHeader("Event-Output-Mode").(string)
-		}
-	}
+	// Get Nuclio Event body
+	body := event.GetBody()
 
 	// Check for empty body
 	if len(body) == 0 {
@@ -127,53 +133,50 @@ func Handler(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
 		}, nil
 	}
 
-	var logEvent LogEvent
-
 	// Unmarshalling LogEvent
-
-	err := json.Unmarshal([]byte(body), &logEvent)
+	logEvent, err := pipeline.ParseLogEvent(body)
 
 	// Catching LogEvent unmarshalling errors
 	if err != nil {
 		context.Logger.Debug("Unmarshall LogEvent:", err)
 	}
 
-	// Setting up field key/value map
-	logEvent.Fields = map[string]string{}
-
-	// Fetching fields from event
-	logEvent = getEventFields(regexExtracts, logEvent, eventOutputMode, fieldPrefixMode, context)
-
-	// Fetching internal fields from meta element
-	metaFields := getMetaFields(logEvent, context)
-
-	// Adding subsecond resolution to time element
-	metaFields.Time = metaFields.Time + metaFields.Fields["_subsecond"]
-	fieldsJSON, _ := json.Marshal(metaFields)
-
-	// Throw away non HEC conform data
-	metaRegex := `("meta.*?",|"_subsecond.*?",)`
-	r, err := regexp.Compile(metaRegex)
-	fieldsJSON = []byte(r.ReplaceAllString(string(fieldsJSON), ""))
+	// Get Splunk Event Optimizer setting from header (normal, minimal, kv, none)
+	logEvent.OutputMode = getHeaderString(event, "Event-Output-Mode", inputConfig.EventOutputMode)
 
-	context.Logger.Debug("fieldsJSON: %s", fieldsJSON)
+	// Get Splunk Field Prefixer setting from header (normal, prefix)
+	logEvent.PrefixFields = getHeaderString(event, "Field-Prefix-Mode", inputConfig.FieldPrefixMode) == "prefix"
 
-	req, err := http.NewRequest("POST", myHECConnection.URL, bytes.NewBuffer(fieldsJSON))
-	req.Header.Set("Authorization", myHECConnection.Authentication)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	logEvents, err := fieldPipeline.Process(logEvent, context.Logger)
 	if err != nil {
-		panic(err)
+		context.Logger.ErrorWith("Pipeline error", "err", err)
+		return nuclio.Response{
+			StatusCode:  500,
+			ContentType: "application/text",
+			Body:        []byte(err.Error()),
+		}, nil
 	}
-	defer resp.Body.Close()
 
-	context.Logger.InfoWith("HEC", "response Status:: %s", resp.Status)
-	//context.Logger.InfoWith("HEC", "response Headers:: %s", resp.Header)
+	// Filtered, deduplicated, sampled out or aggregated
+	if len(logEvents) == 0 {
+		return nuclio.Response{
+			StatusCode:  200,
+			ContentType: "application/text",
+			Body:        []byte("Event dropped"),
+		}, nil
+	}
 
-	bodyHEC, _ := ioutil.ReadAll(resp.Body)
-	context.Logger.InfoWith("HEC", "response Headers:: %s", bodyHEC)
+	// Stages may turn one event into several, those are returned as array
+	var fieldsJSON []byte
+	if len(logEvents) == 1 {
+		fieldsJSON, _ = json.Marshal(pipeline.GetHECEvent(logEvents[0]))
+	} else {
+		hecEvents := make([]pipeline.HECEvent, len(logEvents))
+		for i, logEvent := range logEvents {
+			hecEvents[i] = pipeline.GetHECEvent(logEvent)
+		}
+		fieldsJSON, _ = json.Marshal(hecEvents)
+	}
 
 	return nuclio.Response{
 		StatusCode:  200,
@@ -183,287 +186,52 @@ func Handler(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
 
 }
 
-func doRegexMatch(r *regexp.Regexp, str string) map[string]string {
-
-	match := r.FindStringSubmatch(str)
-
-	if match != nil {
-		subMatchMap := make(map[string]string)
-		for i, name := range r.SubexpNames() {
-			if i != 0 {
-				subMatchMap[name] = match[i]
-			}
-		}
-		return subMatchMap
-
-	}
-	return nil
-}
-
-func getRegexExtracts(container *v3io.Container, context *nuclio.Context) []RegexExtract {
-
-	listBucketResponse, listBucketerr := container.Sync.ListBucket(&v3io.ListBucketInput{
-		Path: "/conf/props/",
-	})
-
-	//context.Logger.DebugWith("ListBucketResponse ", "resp", listBucketResponse)
-	if listBucketerr != nil {
-		context.Logger.ErrorWith("ListBucketerr ", "resp", listBucketerr)
-	}
-
-	respBody := listBucketResponse.Body()
-
-	//context.Logger.DebugWith("ListBucketResponse", "respBody", respBody)
-
-	var listBucketResult ListBucketResult
-
-	err := xml.Unmarshal((respBody), &listBucketResult)
-	if err != nil {
-		context.Logger.ErrorWith("Unmarshal error: %v", err)
-	}
-
-	sourcetypeRegex := `props\/(?P<sourcetype>.*?)\/`
-	r, _ := regexp.Compile(sourcetypeRegex)
-
-	// Set loop variable to false first
-	var last = false
-
-	// Set marker initially to empty
-	var marker string
-
-	// Define slice for regexExtracts
-
-	var regexExtracts = make([]RegexExtract, 0)
-
-	// Loop over Regex Classes
-
-	for prefix := range listBucketResult.CommonPrefixes {
-		//context.Logger.DebugWith("ListBucketResult", "prefix:", prefix)
-
-		str := listBucketResult.CommonPrefixes[prefix].Prefix
-
-		match := r.FindStringSubmatch(str)
-
-		for i, sourcetype := range match {
-			if i != 0 {
-				//context.Logger.DebugWith("ListBucketResult", "sourcetype:", sourcetype)
-
-				for last == false {
-
-					GetItemsResponse, GetItemserr := container.Sync.GetItems(&v3io.GetItemsInput{
-						Path:           "conf/props/" + sourcetype + "/extract/",
-						AttributeNames: []string{"*"},
-						Limit:          1000,
-						Marker:         marker})
-
-					// Return nil if no regex found for sourcetype
-					if GetItemserr != nil {
-						context.Logger.WarnWith("Get Item *err*", "err", GetItemserr)
-						return nil
-					}
-
-					//GetItemsOutput := GetItemsResponse.Output.(*v3io.GetItemsOutput)
-					//context.Logger.DebugWith("GetItems ", "resp", GetItemsOutput)
-
-					items := GetItemsResponse.Output.(*v3io.GetItemsOutput).Items
-
-					for item := range items {
-
-						class := items[item]["class"]
-						//context.Logger.DebugWith("items", "class", class)
-
-						regex := items[item]["regex"]
-						//context.Logger.DebugWith("items", "regex", regex)
-
-						regexExtracts = append(regexExtracts, RegexExtract{sourcetype, class.(string), regex.(string)})
-
-					}
-
-					marker = GetItemsResponse.Output.(*v3io.GetItemsOutput).NextMarker
-					last = GetItemsResponse.Output.(*v3io.GetItemsOutput).Last
-
-				}
-
-			}
-		}
-	}
-
-	return regexExtracts
-
-}
-
-func getHTTPEventCollectorConnection(container *v3io.Container, context *nuclio.Context) HECConnection {
-	GetItemResponse, GetItemerr := container.Sync.GetItem(&v3io.GetItemInput{
-		Path:           "/conf/outputs/hec/0",
-		AttributeNames: []string{"*"}})
-	if GetItemerr != nil {
-		context.Logger.ErrorWith("Get HEC Connection *err*", "err", GetItemerr)
-	} else {
-		GetItemOutput := GetItemResponse.Output.(*v3io.GetItemOutput)
-		context.Logger.InfoWith("Get HEC Connection ", "resp", GetItemOutput)
-	}
-
-	item := GetItemResponse.Output.(*v3io.GetItemOutput).Item
+// Function to read a header as string, header types differ between nuclio and nuclio-test invocations
+func getHeaderString(event nuclio.Event, name string, defaultValue string) string {
 
-	var myHECConnection HECConnection
-
-	myHECConnection.URL = item["url"].(string)
-	myHECConnection.Authentication = item["authorization"].(string)
-
-	return myHECConnection
-}
-
-// Function to add meta fields to field list
-func getMetaFields(logEvent LogEvent, context *nuclio.Context) LogEvent {
-
-	var fields map[string]string
-
-	// Define regexes for internal fields
-	regexExtracts := make([]RegexExtract, 0)
-	regexExtracts = append(regexExtracts, RegexExtract{Class: "_subsecond", Regex: "_subsecond::(?P<_subsecond>\\S+)"})
-	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_second", Regex: "date_second::(?P<date_second>\\d+)"})
-	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_hour", Regex: "date_hour::(?P<date_hour>\\d+)"})
-	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_year", Regex: "date_year::(?P<date_second>\\d+)"})
-	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_month", Regex: "date_month::(?P<date_month>\\w+)"})
-	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_wday", Regex: "date_wday::(?P<date_wday>\\w+)"})
-	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_zone", Regex: "date_zone::(?P<date_zone>\\w+)"})
-
-	for _, regexExtract := range regexExtracts {
-		//context.Logger.Debug("Meta Regex Extract Name: %v", regexExtract.Class)
-		//context.Logger.Debug("Meta Regex Extract Regex: %v", regexExtract.Regex)
-
-		// Compiling regex
-		r, err := regexp.Compile(regexExtract.Regex)
-
-		// Catching regex errors
-		if err != nil {
-			context.Logger.Error("Regex Error:", regexExtract.Regex)
-		}
-
-		fields = doRegexMatch(r, logEvent.Meta)
-		//context.Logger.Debug("Fields: %s", fields)
-
-		if fields != nil {
-			for key, value := range fields {
-				logEvent.Fields[key] = value
-			}
-
-			//context.Logger.Debug("logEvent: %s", logEvent)
-		}
+	switch value := event.GetHeader(name).(type) {
+	case []byte:
+		return string(value)
+	case string:
+		return value
 	}
-	return logEvent
 
+	return defaultValue
 }
 
-// Function to add event fields to field list
-func getEventFields(regexExtracts []RegexExtract, logEvent LogEvent, eventOutputMode string, fieldPrefixMode string, context *nuclio.Context) LogEvent {
+func main() {
 
-	regexFoundFlag := false
+	// Run offline against the sample config, posting to a fake HEC
+	hecServer := hectest.NewServer("00000000-0000-0000-0000-000000000000")
+	defer hecServer.Close()
 
-	for i := range regexExtracts {
-		if regexExtracts[i].Sourcetype == logEvent.Sourcetype {
-			regexFoundFlag = true
-		}
-	}
-
-	// Nothing to do if regex is not found for event
-	if regexFoundFlag == false {
-		return logEvent
+	dirSource, err := pipeline.NewDirConfigSource("testdata/conf")
+	if err != nil {
+		panic(err)
 	}
+	dirSource.SetItem("/conf/outputs/hec/0", hecServer.Connection())
+	configSource = dirSource
 
-	var fields map[string]string
-
-	for _, regexExtract := range regexExtracts {
-
-		//context.Logger.Debug("Event Regex Extract Name: %v", regexExtract.Class)
-		//context.Logger.Debug("Event Regex Extract Regex: %v", regexExtract.Regex)
-
-		// Compiling regex
-		r, err := regexp.Compile(regexExtract.Regex)
-
-		// Catching regex errors
-		if err != nil {
-			context.Logger.Error("Regex Error:", regexExtract.Regex)
-		}
-
-		// Running Regex over
-		fields = doRegexMatch(r, logEvent.Event)
-		//context.Logger.Debug("Fields: %s", fields)
-
-		if fields != nil {
-			for key, value := range fields {
-				if fieldPrefixMode == "prefix" {
-					key = "nuclio." + key
-				}
-
-				logEvent.Fields[key] = value
-			}
-
-			//context.Logger.Debug("logEvent: %s", logEvent)
-
-		}
-
+	// Create TestContext and specify the function name, verbose, data
+	tc, err := nutest.NewTestContext(Handler, true, nil)
+	if err != nil {
+		panic(err)
 	}
 
-	eventOutputMode = "normal"
-
-	// Output only segments, drop segmenter characters
-
-	if eventOutputMode == "minimal" {
-		logEvent.Event = ""
-		for _, value := range logEvent.Fields {
-			logEvent.Event = value + " " + logEvent.Event
-		}
-
-		segmentersRegex := `[^A-Za-z0-9]`
-
-		r, err := regexp.Compile(segmentersRegex)
-
-		// Catchin regex errors
-		if err != nil {
-			context.Logger.Error("Regex Error:", segmentersRegex)
-		}
-
-		logEvent.Event = r.ReplaceAllString(logEvent.Event, " ")
-	} else if eventOutputMode == "kv" {
-		logEvent.Event = ""
-		for key, value := range logEvent.Fields {
-			logEvent.Event = key + "=\"" + value + "\" " + logEvent.Event
-
-		}
-	} else if eventOutputMode == "none" {
-		logEvent.Event = "-"
+	if err = tc.InitContext(InitContext); err != nil {
+		panic(err)
 	}
 
-	return logEvent
-}
-
-func main() {
-
-	data := nutest.DataBind{Name: "db0", Url: "10.90.1.171:8081", Container: "splunk"}
-
-	// Create TestContext and specify the function name, verbose, data
-	tc, err := nutest.NewTestContext(Handler, true, &data)
+	event, err := ioutil.ReadFile("testdata/tests/mysourcetype.json")
 	if err != nil {
 		panic(err)
 	}
 
-	err = tc.InitContext(InitContext)
-
 	// Create a new test event
 	testEvent := nutest.TestEvent{
 		Path:    "/",
 		Headers: map[string]interface{}{"Event-Output-Mode": "none", "Field-Prefix-Mode": "prefix"},
-		Body: []byte(`
-		{
-			"time": "1521751024.814",
-			"sourcetype": "cisco:asa",
-			"meta": "date_second::59 date_hour::22 date_minute::0 date_year::2018 date_month::march date_mday::21 date_wday::wednesday date_zone::60 mytestfield1::bla mytestfield1::\"bla\"", 
-			"host": "myhost",
-			"source": "127.0.0.1",
-			"index": "main",
-			"event": "Mar 23 19:59:58 pix-inside %PIX-4-106023: Deny protocol 4 src outside:210.217.159.25 dst inside :10.87.80.86 by access-group \"ACL-FROM-OUTSIDE\""
-		}`),
+		Body:    event,
 	}
 
 	// Invoke the tested function with the new event and log it's output
@@ -474,4 +242,14 @@ func main() {
 
 	// Log results
 	tc.Logger.InfoWith("Run complete", "Body", responseBody, "err", err)
+
+	for _, hecEvent := range hecServer.Events() {
+		tc.Logger.InfoWith("HEC received", "sourcetype", hecEvent.Sourcetype, "fields", hecEvent.Fields)
+	}
+
+	// Scrape the metrics counted for the event
+	resp, err = tc.Invoke(&nutest.TestEvent{Path: "/metrics"})
+	if err == nil {
+		tc.Logger.InfoWith("Metrics", "Body", string(resp.(nuclio.Response).Body))
+	}
 }
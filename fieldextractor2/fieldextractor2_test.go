@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
+	"github.com/my2ndhead/nuclio_event_etl/pipeline/hectest"
+	"github.com/nuclio/nuclio-sdk-go"
+	"github.com/v3io/v3io-go-http"
+)
+
+const testEventBody = `{"time": "15000000000.500", "sourcetype": "mysourcetype", "host": "myhost", "source": "mysource",
+	"event": "name=\"Kent\" firstname=\"Clark\" address=\"101 mainstreet, New York\""}`
+
+// testEvent Struct, an HTTP trigger event, the methods not overridden are not used by the handler
+type testEvent struct {
+	nuclio.Event
+	path    string
+	body    []byte
+	headers map[string]interface{}
+}
+
+func (event *testEvent) GetPath() string {
+	return event.path
+}
+
+func (event *testEvent) GetBody() []byte {
+	return event.body
+}
+
+func (event *testEvent) GetHeader(key string) interface{} {
+	return event.headers[key]
+}
+
+func (event *testEvent) GetHeaders() map[string]interface{} {
+	return event.headers
+}
+
+// Function to initialize the function with the extractions of mysourcetype, posting to a fake HEC
+func setupFunction(t *testing.T) (*nuclio.Context, *hectest.Server) {
+
+	hecServer := hectest.NewServer("00000000-0000-0000-0000-000000000000")
+
+	source := pipeline.NewMemoryConfigSource()
+	source.SetItem("/conf/outputs/hec/0", hecServer.Connection())
+	source.SetClass("mysourcetype", "extract", "name,firstname", v3io.Item{"regex": `name="(?P<name>\w+)".*?firstname="(?P<firstname>\w+)"`})
+	source.SetClass("mysourcetype", "extract", "address", v3io.Item{"regex": `address=(?P<address>"[^"]*")`})
+	source.SetClass("mysourcetype", "filter", "debug", v3io.Item{"action": "exclude", "regex": `DEBUG`})
+	configSource = source
+
+	context := &nuclio.Context{Logger: pipeline.StdLogger{}}
+
+	if err := InitContext(context); err != nil {
+		hecServer.Close()
+		t.Fatalf("InitContext: %v", err)
+	}
+
+	return context, hecServer
+}
+
+// Function to invoke the handler, failing the test on errors
+func invoke(t *testing.T, context *nuclio.Context, event *testEvent) nuclio.Response {
+
+	result, err := Handler(context, event)
+	if err != nil {
+		t.Fatalf("Handler: %v", err)
+	}
+
+	response, ok := result.(nuclio.Response)
+	if !ok {
+		t.Fatalf("Handler returned %T, want nuclio.Response", result)
+	}
+
+	return response
+}
+
+func TestHandlerOutputModes(t *testing.T) {
+
+	context, hecServer := setupFunction(t)
+	defer hecServer.Close()
+
+	tests := []struct {
+		mode  string
+		event string
+	}{
+		{"normal", `name="Kent" firstname="Clark" address="101 mainstreet, New York"`},
+		{"minimal", "Kent"},
+		{"kv", `name="Kent"`},
+		{"none", "-"},
+	}
+
+	for _, test := range tests {
+
+		hecServer.Reset()
+
+		response := invoke(t, context, &testEvent{
+			path:    "/",
+			body:    []byte(testEventBody),
+			headers: map[string]interface{}{"Event-Output-Mode": test.mode, "Field-Prefix-Mode": "normal"},
+		})
+
+		if response.StatusCode != 200 {
+			t.Fatalf("%s: status %d: %s", test.mode, response.StatusCode, response.Body)
+		}
+
+		events := hecServer.Events()
+		if len(events) != 1 {
+			t.Fatalf("%s: HEC received %d events, want 1", test.mode, len(events))
+		}
+
+		hecEvent := events[0]
+
+		if hecEvent.Sourcetype != "mysourcetype" || hecEvent.Host != "myhost" || hecEvent.Source != "mysource" {
+			t.Errorf("%s: envelope %s/%s/%s, want mysourcetype/myhost/mysource", test.mode, hecEvent.Sourcetype, hecEvent.Host, hecEvent.Source)
+		}
+
+		if !strings.Contains(hecEvent.Event, test.event) {
+			t.Errorf("%s: event %q does not contain %q", test.mode, hecEvent.Event, test.event)
+		}
+
+		if test.mode == "minimal" && strings.ContainsAny(hecEvent.Event, `="`) {
+			t.Errorf("minimal: event %q still contains segmenters", hecEvent.Event)
+		}
+
+		for name, value := range map[string]string{"name": "Kent", "firstname": "Clark", "address": `"101 mainstreet, New York"`} {
+			if hecEvent.Fields[name] != value {
+				t.Errorf("%s: field %s is %v, want %q", test.mode, name, hecEvent.Fields[name], value)
+			}
+		}
+	}
+}
+
+func TestHandlerFieldPrefix(t *testing.T) {
+
+	context, hecServer := setupFunction(t)
+	defer hecServer.Close()
+
+	invoke(t, context, &testEvent{
+		path:    "/",
+		body:    []byte(testEventBody),
+		headers: map[string]interface{}{"Field-Prefix-Mode": "prefix"},
+	})
+
+	events := hecServer.Events()
+	if len(events) != 1 {
+		t.Fatalf("HEC received %d events, want 1", len(events))
+	}
+
+	if events[0].Fields[pipeline.FieldPrefix+"name"] != "Kent" {
+		t.Errorf("fields %v, want %sname", events[0].Fields, pipeline.FieldPrefix)
+	}
+}
+
+func TestHandlerFilterDrop(t *testing.T) {
+
+	context, hecServer := setupFunction(t)
+	defer hecServer.Close()
+
+	body := strings.Replace(testEventBody, `name=\"Kent\"`, `DEBUG name=\"Kent\"`, 1)
+
+	response := invoke(t, context, &testEvent{path: "/", body: []byte(body)})
+
+	if response.StatusCode != 200 || string(response.Body) != "Event dropped" {
+		t.Errorf("status %d: %s, want the event dropped", response.StatusCode, response.Body)
+	}
+
+	if events := hecServer.Events(); len(events) != 0 {
+		t.Errorf("HEC received %d events of a filtered event", len(events))
+	}
+}
+
+func TestHandlerEmptyBody(t *testing.T) {
+
+	context, hecServer := setupFunction(t)
+	defer hecServer.Close()
+
+	response := invoke(t, context, &testEvent{path: "/"})
+
+	if response.StatusCode != 204 {
+		t.Errorf("status %d, want 204", response.StatusCode)
+	}
+
+	if events := hecServer.Events(); len(events) != 0 {
+		t.Errorf("HEC received %d events of an empty body", len(events))
+	}
+}
+
+func TestHandlerHECError(t *testing.T) {
+
+	context, hecServer := setupFunction(t)
+	defer hecServer.Close()
+
+	hecServer.SetStatus(503)
+
+	response := invoke(t, context, &testEvent{path: "/", body: []byte(testEventBody)})
+
+	if response.StatusCode != 500 {
+		t.Errorf("status %d, want 500 when HEC fails", response.StatusCode)
+	}
+}
@@ -0,0 +1,409 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// MetricRule Struct
+type MetricRule struct {
+	Sourcetype  string        `json:"sourcetype"`
+	Class       string        `json:"class"`
+	Name        string        `json:"name"`
+	Aggregation string        `json:"aggregation"`
+	Field       string        `json:"field"`
+	By          []string      `json:"by"`
+	Window      time.Duration `json:"window"`
+	RawEvents   bool          `json:"rawevents"`
+}
+
+// MetricsConnection Struct
+type MetricsConnection struct {
+	Type           string `json:"type"`
+	URL            string `json:"url"`
+	Authentication string `json:"authentication"`
+	Index          string `json:"index"`
+}
+
+// metricPoint is the aggregated value of one metric and dimension combination in one window
+type metricPoint struct {
+	name       string
+	start      time.Time
+	dimensions map[string]string
+	value      float64
+}
+
+// metricAggregator collects metric points of open windows
+type metricAggregator struct {
+	lock   sync.Mutex
+	points map[string]*metricPoint
+	ends   map[string]time.Time
+}
+
+// AggregateStage Struct
+type AggregateStage struct {
+	MetricRules       []MetricRule
+	MetricsConnection MetricsConnection
+}
+
+var aggregator = &metricAggregator{points: map[string]*metricPoint{}, ends: map[string]time.Time{}}
+
+// Stages are created once per worker, the flusher only once per process
+var metricsFlusherOnce sync.Once
+
+// Function to fetch metric rules from /conf/props/<sourcetype>/metrics/
+func getMetricRules(source ConfigSource, logger Logger) []MetricRule {
+
+	var metricRules = make([]MetricRule, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/metrics/", logger)
+
+		for item := range items {
+
+			metricRule := MetricRule{Sourcetype: sourcetype, Aggregation: "count", Window: 60 * time.Second, RawEvents: true}
+
+			metricRule.Class, _ = items[item]["class"].(string)
+			metricRule.Name, _ = items[item]["name"].(string)
+			metricRule.Field, _ = items[item]["field"].(string)
+
+			if aggregation, ok := items[item]["aggregation"].(string); ok && aggregation != "" {
+				metricRule.Aggregation = aggregation
+			}
+
+			if by, ok := items[item]["by"].(string); ok {
+				metricRule.By = splitFieldList(by)
+			}
+
+			if window, ok := getConfigInt(items[item]["window"]); ok && window > 0 {
+				metricRule.Window = time.Duration(window) * time.Second
+			}
+
+			if _, ok := items[item]["rawevents"]; ok {
+				metricRule.RawEvents = getConfigBool(items[item]["rawevents"])
+			}
+
+			if metricRule.Name == "" {
+				logger.ErrorWith("Metric rule needs a name", "sourcetype", sourcetype, "class", metricRule.Class)
+				continue
+			}
+
+			if metricRule.Aggregation != "count" && (metricRule.Aggregation != "sum" || metricRule.Field == "") {
+				logger.ErrorWith("Metric rule needs aggregation count, or sum with a field", "sourcetype", sourcetype, "class", metricRule.Class)
+				continue
+			}
+
+			metricRules = append(metricRules, metricRule)
+		}
+	}
+
+	return metricRules
+}
+
+// Function to get the metrics output from /conf/outputs/metrics/0
+func getMetricsConnection(source ConfigSource, logger Logger) MetricsConnection {
+
+	var myMetricsConnection MetricsConnection
+
+	item, GetItemerr := source.GetItem("/conf/outputs/metrics/0")
+	if GetItemerr != nil {
+		logger.DebugWith("No metrics output configured", "err", GetItemerr)
+		return myMetricsConnection
+	}
+
+	myMetricsConnection.Type, _ = item["type"].(string)
+	myMetricsConnection.URL, _ = item["url"].(string)
+	myMetricsConnection.Authentication, _ = item["authorization"].(string)
+	myMetricsConnection.Index, _ = item["index"].(string)
+
+	// Metrics go to the HEC metrics format by default
+	if myMetricsConnection.Type == "" {
+		myMetricsConnection.Type = "hec"
+	}
+
+	return myMetricsConnection
+}
+
+// NewAggregateStage creates the stage converting events to metrics and starts flushing them to the metrics output
+func NewAggregateStage(config *Config, logger Logger) *AggregateStage {
+
+	stage := &AggregateStage{MetricRules: config.MetricRules, MetricsConnection: config.MetricsConnection}
+
+	if len(stage.MetricRules) > 0 && stage.MetricsConnection.URL != "" {
+		metricsFlusherOnce.Do(func() {
+			go runMetricsFlusher(stage.MetricsConnection, logger)
+		})
+	}
+
+	return stage
+}
+
+// Process adds the event to the metrics of its sourcetype, events only counted are not passed on
+func (stage *AggregateStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if !getAggregatedEvent(stage.MetricRules, logEvent, logger) {
+		logger.Debug("Event aggregated")
+		return nil, nil
+	}
+
+	return []LogEvent{logEvent}, nil
+}
+
+// Function to add an event to the metrics of its sourcetype, returns false if the raw event should not be sent
+func getAggregatedEvent(metricRules []MetricRule, logEvent LogEvent, logger Logger) bool {
+
+	rawEvents := true
+
+	for _, metricRule := range metricRules {
+
+		// Only apply rules of the event's sourcetype
+		if metricRule.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		value := float64(1)
+		if metricRule.Aggregation == "sum" {
+			fieldValue, ok := getEventField(logEvent, metricRule.Field)
+			if !ok {
+				continue
+			}
+
+			number, err := strconv.ParseFloat(strings.TrimSpace(fieldValue), 64)
+			if err != nil {
+				continue
+			}
+			value = number
+		}
+
+		dimensions := map[string]string{}
+		for _, by := range metricRule.By {
+			switch by {
+			case "host":
+				dimensions[by] = logEvent.Host
+			case "source":
+				dimensions[by] = logEvent.Source
+			case "sourcetype":
+				dimensions[by] = logEvent.Sourcetype
+			case "index":
+				dimensions[by] = logEvent.Index
+			default:
+				dimensions[by], _ = getEventField(logEvent, by)
+			}
+		}
+
+		aggregator.Add(metricRule, dimensions, value, time.Now())
+
+		// Only events counted into a point replace the raw event, others would be lost
+		if !metricRule.RawEvents {
+			rawEvents = false
+		}
+	}
+
+	return rawEvents
+}
+
+// Add adds value to the point of the window containing now
+func (aggregator *metricAggregator) Add(metricRule MetricRule, dimensions map[string]string, value float64, now time.Time) {
+
+	start := now.Truncate(metricRule.Window)
+
+	var dimensionKeys []string
+	for key, dimensionValue := range dimensions {
+		dimensionKeys = append(dimensionKeys, key+"="+dimensionValue)
+	}
+	sort.Strings(dimensionKeys)
+
+	key := metricRule.Sourcetype + "|" + metricRule.Class + "|" + strconv.FormatInt(start.Unix(), 10) + "|" + strings.Join(dimensionKeys, "|")
+
+	aggregator.lock.Lock()
+	defer aggregator.lock.Unlock()
+
+	point, ok := aggregator.points[key]
+	if !ok {
+		point = &metricPoint{name: metricRule.Name, start: start, dimensions: dimensions}
+		aggregator.points[key] = point
+		aggregator.ends[key] = start.Add(metricRule.Window)
+	}
+
+	point.value += value
+}
+
+// Flush removes and returns all points of windows ended before now
+func (aggregator *metricAggregator) Flush(now time.Time) []*metricPoint {
+
+	aggregator.lock.Lock()
+	defer aggregator.lock.Unlock()
+
+	var points []*metricPoint
+
+	for key, end := range aggregator.ends {
+		if !now.Before(end) {
+			points = append(points, aggregator.points[key])
+			delete(aggregator.points, key)
+			delete(aggregator.ends, key)
+		}
+	}
+
+	return points
+}
+
+// Function to flush ended windows to the metrics output, runs for the lifetime of the function
+func runMetricsFlusher(myMetricsConnection MetricsConnection, logger Logger) {
+
+	for now := range time.Tick(time.Second) {
+
+		points := aggregator.Flush(now)
+		if len(points) == 0 {
+			continue
+		}
+
+		var err error
+
+		switch myMetricsConnection.Type {
+		case "prometheus":
+			err = sendPrometheusRemoteWrite(myMetricsConnection, points)
+		default:
+			err = sendHECMetrics(myMetricsConnection, points)
+		}
+
+		if err != nil {
+			metrics.Add("metrics_output_errors_total", float64(len(points)), "type", myMetricsConnection.Type)
+			logger.ErrorWith("Metrics output error", "type", myMetricsConnection.Type, "err", err)
+		}
+	}
+}
+
+// Function to send points in Splunk's HEC metrics format, one event per point
+func sendHECMetrics(connection MetricsConnection, points []*metricPoint) error {
+
+	var body bytes.Buffer
+
+	for _, point := range points {
+
+		fields := map[string]interface{}{
+			"metric_name": point.name,
+			"_value":      point.value,
+		}
+
+		hecMetric := map[string]interface{}{
+			"time":  point.start.Unix(),
+			"event": "metric",
+		}
+
+		for key, value := range point.dimensions {
+			switch key {
+			case "host", "source", "sourcetype":
+				hecMetric[key] = value
+			default:
+				fields[key] = value
+			}
+		}
+
+		if connection.Index != "" {
+			hecMetric["index"] = connection.Index
+		}
+
+		hecMetric["fields"] = fields
+
+		hecMetricJSON, _ := json.Marshal(hecMetric)
+		body.Write(hecMetricJSON)
+	}
+
+	return postMetrics(connection, "application/json", nil, body.Bytes())
+}
+
+var prometheusNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// Function to send points to a Prometheus remote write endpoint
+func sendPrometheusRemoteWrite(connection MetricsConnection, points []*metricPoint) error {
+
+	var writeRequest []byte
+
+	for _, point := range points {
+
+		labels := map[string]string{"__name__": prometheusNameRegex.ReplaceAllString(point.name, "_")}
+		for key, value := range point.dimensions {
+			labels[prometheusNameRegex.ReplaceAllString(key, "_")] = value
+		}
+
+		// Labels have to be sorted by name
+		var names []string
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var timeSeries []byte
+		for _, name := range names {
+			var label []byte
+			label = appendProtoBytes(label, 1, []byte(name))
+			label = appendProtoBytes(label, 2, []byte(labels[name]))
+			timeSeries = appendProtoBytes(timeSeries, 1, label)
+		}
+
+		var sample []byte
+		sample = appendProtoFixed64(sample, 1, math.Float64bits(point.value))
+		sample = appendProtoVarint(sample, 2, uint64(point.start.UnixNano()/int64(time.Millisecond)))
+		timeSeries = appendProtoBytes(timeSeries, 2, sample)
+
+		writeRequest = appendProtoBytes(writeRequest, 1, timeSeries)
+	}
+
+	headers := map[string]string{
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}
+
+	return postMetrics(connection, "application/x-protobuf", headers, snappy.Encode(nil, writeRequest))
+}
+
+// Protobuf wire format helpers for the remote write WriteRequest message
+func appendProtoVarint(buffer []byte, field int, value uint64) []byte {
+	buffer = appendVarint(buffer, uint64(field<<3|0))
+	return appendVarint(buffer, value)
+}
+
+func appendProtoFixed64(buffer []byte, field int, value uint64) []byte {
+	buffer = appendVarint(buffer, uint64(field<<3|1))
+	var fixed [8]byte
+	binary.LittleEndian.PutUint64(fixed[:], value)
+	return append(buffer, fixed[:]...)
+}
+
+func appendProtoBytes(buffer []byte, field int, value []byte) []byte {
+	buffer = appendVarint(buffer, uint64(field<<3|2))
+	buffer = appendVarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+func appendVarint(buffer []byte, value uint64) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(varint[:], value)
+	return append(buffer, varint[:n]...)
+}
+
+func postMetrics(connection MetricsConnection, contentType string, headers map[string]string, body []byte) error {
+
+	requestHeaders := map[string]string{
+		"Content-Type":  contentType,
+		"Authorization": connection.Authentication,
+	}
+	for key, value := range headers {
+		requestHeaders[key] = value
+	}
+
+	_, err := postHTTP("metrics_"+connection.Type, connection.URL, requestHeaders, body)
+
+	return err
+}
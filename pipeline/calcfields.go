@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"sort"
+)
+
+// CalcField Struct
+type CalcField struct {
+	Sourcetype string          `json:"sourcetype"`
+	Class      string          `json:"class"`
+	Field      string          `json:"field"`
+	Expression *EvalExpression `json:"-"`
+}
+
+// CalcStage Struct
+type CalcStage struct {
+	CalcFields []CalcField
+}
+
+// Function to fetch calculated fields from /conf/props/<sourcetype>/eval/, expressions are validated while loading
+func getCalcFields(source ConfigSource, logger Logger) []CalcField {
+
+	var calcFields = make([]CalcField, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/eval/", logger)
+
+		for item := range items {
+
+			class, _ := items[item]["class"].(string)
+			field, _ := items[item]["field"].(string)
+			expression, _ := items[item]["expression"].(string)
+
+			// Field defaults to the class name, like Splunk's EVAL-<field>
+			if field == "" {
+				field = class
+			}
+
+			if field == "" || expression == "" {
+				logger.ErrorWith("Calculated field incomplete", "sourcetype", sourcetype, "class", class)
+				continue
+			}
+
+			evalExpression, err := ParseEvalExpression(expression)
+
+			// Skip invalid expressions instead of failing at event time
+			if err != nil {
+				logger.ErrorWith("Calculated field expression error", "sourcetype", sourcetype, "class", class, "expression", expression, "err", err)
+				continue
+			}
+
+			calcFields = append(calcFields, CalcField{sourcetype, class, field, evalExpression})
+		}
+	}
+
+	// Calculated fields are evaluated in class order, later ones may use the results of earlier ones
+	sort.SliceStable(calcFields, func(i, j int) bool {
+		return calcFields[i].Class < calcFields[j].Class
+	})
+
+	return calcFields
+}
+
+// NewCalcStage creates the stage adding calculated fields
+func NewCalcStage(config *Config) *CalcStage {
+	return &CalcStage{CalcFields: config.CalcFields}
+}
+
+// Process adds calculated fields to field list
+func (stage *CalcStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	for _, calcField := range stage.CalcFields {
+
+		// Only apply calculated fields of the event's sourcetype
+		if calcField.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		key := calcField.Field
+		if logEvent.PrefixFields {
+			key = FieldPrefix + key
+		}
+
+		value := calcField.Expression.Eval(logEvent.Fields)
+
+		// Null results remove the field
+		if value == nil {
+			delete(logEvent.Fields, key)
+			continue
+		}
+
+		logEvent.Fields[key] = evalToString(value)
+	}
+
+	return []LogEvent{logEvent}, nil
+}
@@ -0,0 +1,246 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ClassifyRule Struct, a hint that an event is of Sourcetype, Confidence is a percentage
+type ClassifyRule struct {
+	Sourcetype string   `json:"sourcetype"`
+	Class      string   `json:"class"`
+	Type       string   `json:"type"`
+	Regex      string   `json:"regex"`
+	Keys       []string `json:"keys"`
+	Confidence int      `json:"confidence"`
+
+	regex *regexp.Regexp
+}
+
+// ClassifyConfig Struct, events below MinConfidence get the Default sourcetype, if set
+type ClassifyConfig struct {
+	Default       string `json:"default"`
+	MinConfidence int    `json:"minconfidence"`
+}
+
+// ClassifyStage Struct
+type ClassifyStage struct {
+	ClassifyRules  []ClassifyRule
+	ClassifyConfig ClassifyConfig
+}
+
+// Default confidence of a matching rule by type, signatures are specific, headers are shared by many sources
+var classifyConfidence = map[string]int{"signature": 90, "json": 80, "header": 60}
+
+// Field the confidence of a classification is stored in
+const confidenceField = "sourcetype_confidence"
+
+// Function to fetch classification rules from /conf/props/<sourcetype>/classify/
+func getClassifyRules(source ConfigSource, logger Logger) []ClassifyRule {
+
+	var classifyRules = make([]ClassifyRule, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/classify/", logger)
+
+		for item := range items {
+
+			classifyRule := ClassifyRule{Sourcetype: sourcetype}
+
+			classifyRule.Class, _ = items[item]["class"].(string)
+			classifyRule.Type, _ = items[item]["type"].(string)
+			classifyRule.Regex, _ = items[item]["regex"].(string)
+
+			if keys, ok := items[item]["keys"].(string); ok {
+				classifyRule.Keys = splitFieldList(keys)
+			}
+
+			if classifyRule.Type == "" {
+				classifyRule.Type = "signature"
+			}
+
+			defaultConfidence, ok := classifyConfidence[classifyRule.Type]
+			if !ok {
+				logger.ErrorWith("Classify type has to be signature, header or json", "sourcetype", sourcetype, "class", classifyRule.Class, "type", classifyRule.Type)
+				continue
+			}
+
+			classifyRule.Confidence = defaultConfidence
+			if confidence, ok := getConfigInt(items[item]["confidence"]); ok {
+				classifyRule.Confidence = confidence
+			}
+
+			if classifyRule.Confidence < 1 || classifyRule.Confidence > 100 {
+				logger.ErrorWith("Classify confidence has to be between 1 and 100", "sourcetype", sourcetype, "class", classifyRule.Class)
+				continue
+			}
+
+			if classifyRule.Type == "json" {
+				if len(classifyRule.Keys) == 0 {
+					logger.ErrorWith("Classify json rule needs keys", "sourcetype", sourcetype, "class", classifyRule.Class)
+					continue
+				}
+			} else {
+				regex := classifyRule.Regex
+
+				// Header patterns only match at the start of the event
+				if classifyRule.Type == "header" {
+					regex = "^(?:" + regex + ")"
+				}
+
+				r, err := regexp.Compile(regex)
+				if err != nil || classifyRule.Regex == "" {
+					logger.ErrorWith("Classify regex error", "sourcetype", sourcetype, "class", classifyRule.Class, "err", err)
+					continue
+				}
+
+				classifyRule.regex = r
+			}
+
+			classifyRules = append(classifyRules, classifyRule)
+		}
+	}
+
+	return classifyRules
+}
+
+// Function to fetch the default sourcetype and minimum confidence from /conf/classify/0
+func getClassifyConfig(source ConfigSource, logger Logger) ClassifyConfig {
+
+	classifyConfig := ClassifyConfig{MinConfidence: 50}
+
+	item, GetItemerr := source.GetItem("/conf/classify/0")
+	if GetItemerr != nil {
+		logger.DebugWith("No classification defaults configured", "err", GetItemerr)
+		return classifyConfig
+	}
+
+	classifyConfig.Default, _ = item["default"].(string)
+
+	if minConfidence, ok := getConfigInt(item["minconfidence"]); ok {
+		classifyConfig.MinConfidence = minConfidence
+	}
+
+	return classifyConfig
+}
+
+// NewClassifyStage creates the stage setting the sourcetype of events without one
+func NewClassifyStage(config *Config) *ClassifyStage {
+	return &ClassifyStage{ClassifyRules: config.ClassifyRules, ClassifyConfig: config.ClassifyConfig}
+}
+
+// Process sets the sourcetype of events without one to the most likely one, or the default
+func (stage *ClassifyStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if logEvent.Sourcetype != "" {
+		return []LogEvent{logEvent}, nil
+	}
+
+	sourcetype, class, confidence := Classify(stage.ClassifyRules, logEvent.Event)
+
+	if confidence < stage.ClassifyConfig.MinConfidence {
+		if stage.ClassifyConfig.Default == "" {
+			metrics.Inc("events_unclassified_total")
+			return []LogEvent{logEvent}, nil
+		}
+		sourcetype, class, confidence = stage.ClassifyConfig.Default, "default", 0
+	}
+
+	logger.DebugWith("Event classified", "sourcetype", sourcetype, "class", class, "confidence", confidence)
+
+	metrics.Inc("events_classified_total", "sourcetype", sourcetype, "class", class)
+
+	logEvent.Sourcetype = sourcetype
+	logEvent = addEventFields(logEvent, map[string]string{confidenceField: strconv.Itoa(confidence)})
+
+	return []LogEvent{logEvent}, nil
+}
+
+// Classify returns the most likely sourcetype of event, the class of its most confident matching rule and the
+// combined confidence of all its matching rules, an empty sourcetype if no rule matches
+func Classify(classifyRules []ClassifyRule, event string) (string, string, int) {
+
+	// Parsed once for all json rules, nil if the event is no JSON object
+	var object map[string]interface{}
+	parsed := false
+
+	// Probability that the event is not of the sourcetype, the rules are taken as independent hints
+	doubt := map[string]float64{}
+	bestRule := map[string]ClassifyRule{}
+
+	for _, classifyRule := range classifyRules {
+
+		matched := false
+
+		if classifyRule.Type == "json" {
+			if !parsed {
+				parsed = true
+				if strings.HasPrefix(strings.TrimSpace(event), "{") {
+					json.Unmarshal([]byte(event), &object)
+				}
+			}
+			matched = object != nil && hasKeys(object, classifyRule.Keys)
+		} else {
+			matched = classifyRule.regex.MatchString(event)
+		}
+
+		if !matched {
+			continue
+		}
+
+		if _, ok := doubt[classifyRule.Sourcetype]; !ok {
+			doubt[classifyRule.Sourcetype] = 1
+		}
+		doubt[classifyRule.Sourcetype] *= 1 - float64(classifyRule.Confidence)/100
+
+		if best, ok := bestRule[classifyRule.Sourcetype]; !ok || classifyRule.Confidence > best.Confidence {
+			bestRule[classifyRule.Sourcetype] = classifyRule
+		}
+	}
+
+	var sourcetypes []string
+	for sourcetype := range doubt {
+		sourcetypes = append(sourcetypes, sourcetype)
+	}
+
+	if len(sourcetypes) == 0 {
+		return "", "", 0
+	}
+
+	// Ties go to the first sourcetype by name, so the result does not depend on the config order
+	sort.Slice(sourcetypes, func(i, j int) bool {
+		if doubt[sourcetypes[i]] != doubt[sourcetypes[j]] {
+			return doubt[sourcetypes[i]] < doubt[sourcetypes[j]]
+		}
+		return sourcetypes[i] < sourcetypes[j]
+	})
+
+	sourcetype := sourcetypes[0]
+
+	return sourcetype, bestRule[sourcetype].Class, int((1-doubt[sourcetype])*100 + 0.5)
+}
+
+// Function to check if a JSON object has all keys, nested keys are given as a.b
+func hasKeys(object map[string]interface{}, keys []string) bool {
+
+	for _, key := range keys {
+
+		var value interface{} = object
+
+		for _, part := range strings.Split(key, ".") {
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			if value, ok = nested[part]; !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
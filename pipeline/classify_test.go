@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/v3io/v3io-go-http"
+)
+
+// Function to load the classify rules of a few sourcetypes, including invalid ones which are skipped
+func testClassifyRules(t *testing.T) []ClassifyRule {
+
+	source := NewMemoryConfigSource()
+	source.SetClass("cisco:asa", "classify", "asa", v3io.Item{"regex": `%ASA-\d-\d{6}`})
+	source.SetClass("cisco:asa", "classify", "syslog", v3io.Item{"type": "header", "regex": `<\d+>`})
+	source.SetClass("linux:syslog", "classify", "syslog", v3io.Item{"type": "header", "regex": `<\d+>`})
+	source.SetClass("aws:cloudtrail", "classify", "event", v3io.Item{"type": "json", "keys": "eventVersion,userIdentity.type"})
+	source.SetClass("invalid", "classify", "type", v3io.Item{"type": "footer", "regex": "x"})
+	source.SetClass("invalid", "classify", "regex", v3io.Item{"regex": "("})
+	source.SetClass("invalid", "classify", "keys", v3io.Item{"type": "json"})
+	source.SetClass("invalid", "classify", "confidence", v3io.Item{"regex": "x", "confidence": "101"})
+
+	classifyRules := getClassifyRules(source, StdLogger{})
+
+	if len(classifyRules) != 4 {
+		t.Fatalf("%d classify rules loaded, want the 4 valid ones", len(classifyRules))
+	}
+
+	return classifyRules
+}
+
+func TestClassify(t *testing.T) {
+
+	classifyRules := testClassifyRules(t)
+
+	tests := []struct {
+		event      string
+		sourcetype string
+		class      string
+		confidence int
+	}{
+		// Signature and header combined, 1 - 0.1 * 0.4
+		{"<166>Jan 1 00:00:00 fw %ASA-6-302013: Built outbound TCP connection", "cisco:asa", "asa", 96},
+		{"fw %ASA-6-302013: Built", "cisco:asa", "asa", 90},
+		// The same header for two sourcetypes, the tie goes to the first by name
+		{"<13>Jan 1 00:00:00 host sshd: session opened", "cisco:asa", "syslog", 60},
+		// Headers only match at the start
+		{"message <13>", "", "", 0},
+		{`{"eventVersion": "1.08", "userIdentity": {"type": "IAMUser"}}`, "aws:cloudtrail", "event", 80},
+		{`{"eventVersion": "1.08", "userIdentity": "root"}`, "", "", 0},
+		{"not json", "", "", 0},
+	}
+
+	for _, test := range tests {
+
+		sourcetype, class, confidence := Classify(classifyRules, test.event)
+
+		if sourcetype != test.sourcetype || class != test.class || confidence != test.confidence {
+			t.Errorf("Classify(%q) = %s/%s/%d, want %s/%s/%d", test.event, sourcetype, class, confidence,
+				test.sourcetype, test.class, test.confidence)
+		}
+	}
+}
+
+func TestClassifyStage(t *testing.T) {
+
+	stage := &ClassifyStage{
+		ClassifyRules:  testClassifyRules(t),
+		ClassifyConfig: ClassifyConfig{Default: "unknown", MinConfidence: 70},
+	}
+
+	tests := []struct {
+		sourcetype string
+		event      string
+		want       string
+		confidence string
+	}{
+		{"", "fw %ASA-6-302013: Built", "cisco:asa", "90"},
+		// Below the minimum confidence
+		{"", "<13>Jan 1 00:00:00 host sshd", "unknown", "0"},
+		// Events with a sourcetype are passed as they are
+		{"mysourcetype", "fw %ASA-6-302013: Built", "mysourcetype", ""},
+	}
+
+	for _, test := range tests {
+
+		logEvent := NewLogEvent()
+		logEvent.Sourcetype = test.sourcetype
+		logEvent.Event = test.event
+
+		logEvents, err := stage.Process(logEvent, StdLogger{})
+		if err != nil || len(logEvents) != 1 {
+			t.Fatalf("%q: %d events, err %v", test.event, len(logEvents), err)
+		}
+
+		if logEvents[0].Sourcetype != test.want || logEvents[0].Fields[confidenceField] != test.confidence {
+			t.Errorf("%q: sourcetype %s with confidence %q, want %s with %q", test.event, logEvents[0].Sourcetype,
+				logEvents[0].Fields[confidenceField], test.want, test.confidence)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/v3io/v3io-go-http"
+)
+
+// HECConnection Struct
+type HECConnection struct {
+	URL            string `json:"url"`
+	Authentication string `json:"authentication"`
+}
+
+// Config Struct, everything configured below /conf/
+type Config struct {
+	ClassifyRules     []ClassifyRule
+	ClassifyConfig    ClassifyConfig
+	RegexExtracts     []RegexExtract
+	DelimExtracts     []DelimExtract
+	TransformRules    []TransformRule
+	CalcFields        []CalcField
+	FieldAliases      []FieldAlias
+	FieldFilters      []FieldFilter
+	Lookups           map[string]*Lookup
+	LookupFields      []LookupField
+	GeoIPCityDB       *maxminddb.Reader
+	GeoIPASNDB        *maxminddb.Reader
+	NetworkZones      []NetworkZone
+	GeoIPFields       []GeoIPField
+	HostConfig        HostConfig
+	HostResolver      *HostResolver
+	FilterRules       []FilterRule
+	DedupRules        []DedupRule
+	SampleRules       []SampleRule
+	RateLimits        []RateLimit
+	RedactRules       []RedactRule
+	MetricRules       []MetricRule
+	MetricsConnection MetricsConnection
+	HECConnection     HECConnection
+
+	// Container for the stages keeping state in v3io (dedup, tokenization)
+	Container *v3io.Container
+}
+
+// LoadConfig reads the complete configuration from v3io
+func LoadConfig(container *v3io.Container, logger Logger) (*Config, error) {
+
+	config, err := LoadConfigFromSource(NewV3IOConfigSource(container), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Container = container
+
+	return config, nil
+}
+
+// LoadConfigFromSource reads the complete configuration from source, stages keeping state in v3io need Container set.
+// Invalid parts are logged and skipped, except those which would leave data unmasked.
+func LoadConfigFromSource(source ConfigSource, logger Logger) (*Config, error) {
+
+	config := &Config{}
+
+	var err error
+
+	// Get classification rules for events without sourcetype
+	config.ClassifyRules = getClassifyRules(source, logger)
+	config.ClassifyConfig = getClassifyConfig(source, logger)
+
+	// Get Regex Extracts for sourceype
+	if config.RegexExtracts, err = getRegexExtracts(source, logger); err != nil {
+		return nil, err
+	}
+
+	// Get Delimiter Extracts for sourcetype
+	config.DelimExtracts = getDelimExtracts(source, logger)
+
+	// Get routing transforms for sourcetype
+	config.TransformRules = getTransformRules(source, logger)
+
+	// Get Calculated Fields for sourcetype
+	config.CalcFields = getCalcFields(source, logger)
+
+	// Get Field Aliases and Field Filters for sourcetype
+	config.FieldAliases = getFieldAliases(source, logger)
+	config.FieldFilters = getFieldFilters(source, logger)
+
+	// Get Lookup tables and their usage per sourcetype
+	config.Lookups = getLookups(source, logger)
+	config.LookupFields = getLookupFields(source, config.Lookups, logger)
+
+	// Get GeoIP databases, network zones and IP fields per sourcetype
+	config.GeoIPCityDB, config.GeoIPASNDB = getGeoIPDatabases(source, logger)
+	config.NetworkZones = getNetworkZones(source, logger)
+	config.GeoIPFields = getGeoIPFields(source, logger)
+
+	// Get hostname resolution and normalization settings
+	config.HostConfig, config.HostResolver = getHostConfig(source, logger)
+
+	// Get filter rules for sourcetype
+	config.FilterRules = getFilterRules(source, logger)
+
+	// Get dedup rules for sourcetype
+	config.DedupRules = getDedupRules(source, logger)
+
+	// Get sampling rules and rate limits for sourcetype
+	config.SampleRules = getSampleRules(source, logger)
+	config.RateLimits = getRateLimits(source, logger)
+
+	// Get redaction rules for sourcetype
+	if config.RedactRules, err = getRedactRules(source, logger); err != nil {
+		return nil, err
+	}
+
+	// Get metric rules and output for log to metric conversion
+	config.MetricRules = getMetricRules(source, logger)
+	config.MetricsConnection = getMetricsConnection(source, logger)
+
+	// Without output the metrics would pile up unsent, and the events of rules without raw events would be lost
+	if len(config.MetricRules) > 0 && config.MetricsConnection.URL == "" {
+		logger.ErrorWith("Metric rules ignored, no metrics output configured in /conf/outputs/metrics/0", "rules", len(config.MetricRules))
+		config.MetricRules = make([]MetricRule, 0)
+	}
+
+	config.HECConnection = getHTTPEventCollectorConnection(source, logger)
+
+	return config, nil
+}
+
+// LoadExtractionConfig reads only what the route, extract, calc and normalize stages need, for trying extractions
+func LoadExtractionConfig(source ConfigSource, logger Logger) (*Config, error) {
+
+	config := &Config{}
+
+	var err error
+
+	if config.RegexExtracts, err = getRegexExtracts(source, logger); err != nil {
+		return nil, err
+	}
+	config.DelimExtracts = getDelimExtracts(source, logger)
+	config.TransformRules = getTransformRules(source, logger)
+	config.CalcFields = getCalcFields(source, logger)
+	config.FieldAliases = getFieldAliases(source, logger)
+	config.FieldFilters = getFieldFilters(source, logger)
+
+	return config, nil
+}
+
+// Function to list all sourcetypes configured under /conf/props/
+func getSourcetypes(source ConfigSource, logger Logger) []string {
+
+	sourcetypes, err := source.ListDirs("/conf/props/")
+	if err != nil {
+		logger.ErrorWith("ListBucketerr ", "resp", err)
+		return nil
+	}
+
+	return sourcetypes
+}
+
+// Function to fetch all items below a config path
+func getConfigItems(source ConfigSource, path string, logger Logger) []v3io.Item {
+
+	items, err := source.GetItems(path)
+
+	// Return nothing if no config is found below path
+	if err != nil {
+		logger.DebugWith("Get Items *err*", "path", path, "err", err)
+		return nil
+	}
+
+	return items
+}
+
+func getHTTPEventCollectorConnection(source ConfigSource, logger Logger) HECConnection {
+
+	var myHECConnection HECConnection
+
+	item, GetItemerr := source.GetItem("/conf/outputs/hec/0")
+	if GetItemerr != nil {
+		logger.ErrorWith("Get HEC Connection *err*", "err", GetItemerr)
+		return myHECConnection
+	}
+
+	logger.InfoWith("Get HEC Connection ", "resp", item)
+
+	myHECConnection.URL, _ = item["url"].(string)
+	myHECConnection.Authentication, _ = item["authorization"].(string)
+
+	return myHECConnection
+}
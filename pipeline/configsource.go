@@ -0,0 +1,432 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/v3io/v3io-go-http"
+)
+
+// ConfigSource is where the configuration below /conf/ is read from
+type ConfigSource interface {
+	// ListDirs returns the names of the directories below path, e.g. the sourcetypes below /conf/props/
+	ListDirs(path string) ([]string, error)
+
+	// GetItems returns all items below path
+	GetItems(path string) ([]v3io.Item, error)
+
+	// GetItem returns the item at path, an error if it does not exist
+	GetItem(path string) (v3io.Item, error)
+
+	// GetObject returns the content of the object at path
+	GetObject(path string) ([]byte, error)
+}
+
+// ListBucketResult Struct
+type ListBucketResult struct {
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Marker         string         `xml:"Marker"`
+	Delimiter      string         `xml:"Delimiter"`
+	NextMarker     string         `xml:"NextMarker"`
+	MaxKeys        string         `xml:"NextMaxKeys"`
+	IsTruncated    string         `xml:"IsTruncated"`
+	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// CommonPrefix Struct
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// V3IOConfigSource Struct, reads the configuration from a v3io container
+type V3IOConfigSource struct {
+	Container *v3io.Container
+}
+
+// MemoryConfigSource Struct, holds the configuration in memory, for tests and configs read from local files
+type MemoryConfigSource struct {
+
+	// Items and objects by path without leading slash, e.g. conf/props/cisco:asa/extract/conn
+	Items   map[string]v3io.Item
+	Objects map[string][]byte
+
+	lock sync.RWMutex
+}
+
+// DirConfigSource Struct, reads the configuration from a local directory of <sourcetype>.json files as kept by
+// regexuploader sync, other files of the directory are read as objects
+type DirConfigSource struct {
+	*MemoryConfigSource
+
+	Dir string
+}
+
+// OpenConfigSource opens the config source at location, a directory of <sourcetype>.json files or a JSON document
+// exported by regexuploader, or the v3io container if location is empty
+func OpenConfigSource(location string, container *v3io.Container) (ConfigSource, error) {
+
+	if location == "" {
+		if container == nil {
+			return nil, fmt.Errorf("no config source given and no v3io container available")
+		}
+		return NewV3IOConfigSource(container), nil
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, fmt.Errorf("config source %s: %v", location, err)
+	}
+
+	if info.IsDir() {
+		return NewDirConfigSource(location)
+	}
+
+	return NewDocumentConfigSource(location)
+}
+
+// NewV3IOConfigSource creates a config source reading from container
+func NewV3IOConfigSource(container *v3io.Container) *V3IOConfigSource {
+	return &V3IOConfigSource{Container: container}
+}
+
+// ListDirs returns the names of the directories below path
+func (source *V3IOConfigSource) ListDirs(path string) ([]string, error) {
+
+	listBucketResponse, listBucketerr := source.Container.Sync.ListBucket(&v3io.ListBucketInput{
+		Path: path,
+	})
+	if listBucketerr != nil {
+		return nil, listBucketerr
+	}
+
+	var listBucketResult ListBucketResult
+
+	if err := xml.Unmarshal(listBucketResponse.Body(), &listBucketResult); err != nil {
+		return nil, err
+	}
+
+	var dirs = make([]string, 0)
+
+	for _, commonPrefix := range listBucketResult.CommonPrefixes {
+		name := strings.TrimSuffix(commonPrefix.Prefix, "/")
+		dirs = append(dirs, name[strings.LastIndex(name, "/")+1:])
+	}
+
+	return dirs, nil
+}
+
+// GetItems returns all items below path, following the markers
+func (source *V3IOConfigSource) GetItems(path string) ([]v3io.Item, error) {
+
+	// Set loop variable to false first
+	var last = false
+
+	// Set marker initially to empty
+	var marker string
+
+	var items = make([]v3io.Item, 0)
+
+	for last == false {
+
+		GetItemsResponse, GetItemserr := source.Container.Sync.GetItems(&v3io.GetItemsInput{
+			Path:           path,
+			AttributeNames: []string{"*"},
+			Limit:          1000,
+			Marker:         marker})
+		if GetItemserr != nil {
+			return nil, GetItemserr
+		}
+
+		GetItemsOutput := GetItemsResponse.Output.(*v3io.GetItemsOutput)
+
+		items = append(items, GetItemsOutput.Items...)
+
+		marker = GetItemsOutput.NextMarker
+		last = GetItemsOutput.Last
+	}
+
+	return items, nil
+}
+
+// GetItem returns the item at path
+func (source *V3IOConfigSource) GetItem(path string) (v3io.Item, error) {
+
+	GetItemResponse, GetItemerr := source.Container.Sync.GetItem(&v3io.GetItemInput{
+		Path:           path,
+		AttributeNames: []string{"*"}})
+	if GetItemerr != nil {
+		return nil, GetItemerr
+	}
+
+	return GetItemResponse.Output.(*v3io.GetItemOutput).Item, nil
+}
+
+// GetObject returns the content of the object at path
+func (source *V3IOConfigSource) GetObject(path string) ([]byte, error) {
+
+	GetObjectResponse, GetObjecterr := source.Container.Sync.GetObject(&v3io.GetObjectInput{
+		Path: path})
+	if GetObjecterr != nil {
+		return nil, GetObjecterr
+	}
+
+	return GetObjectResponse.Body(), nil
+}
+
+// NewDirConfigSource reads the <sourcetype>.json files of dir
+//
+// A file either maps class names to regexes, which configures the extract section, or sections to classes, where
+// a class is a regex or an object of attributes. Sourcetypes may be escaped in file names, e.g. cisco%3Aasa.json.
+func NewDirConfigSource(dir string) (*DirConfigSource, error) {
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	source := &DirConfigSource{MemoryConfigSource: NewMemoryConfigSource(), Dir: dir}
+
+	for _, path := range paths {
+
+		sourcetype, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var content map[string]interface{}
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		// Plain class to regex maps hold extractions
+		plain := true
+		for _, value := range content {
+			if _, ok := value.(string); !ok {
+				plain = false
+			}
+		}
+
+		sections := map[string]interface{}{"extract": content}
+		if !plain {
+			sections = content
+		}
+
+		for section, classes := range sections {
+
+			if section == "routes" {
+				section = "transforms"
+			}
+
+			classMap, ok := classes.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: section %s has to be an object of classes", path, section)
+			}
+
+			for class, value := range classMap {
+
+				item := v3io.Item{}
+
+				switch value := value.(type) {
+				case string:
+					item["regex"] = value
+				case map[string]interface{}:
+					for name, attribute := range value {
+						item[name] = attribute
+					}
+				default:
+					return nil, fmt.Errorf("%s: %s class %s has to be a regex or an object", path, section, class)
+				}
+
+				source.SetClass(sourcetype, section, class, item)
+			}
+		}
+	}
+
+	return source, nil
+}
+
+// GetObject returns the content of the object at path, files are read relative to the directory
+func (source *DirConfigSource) GetObject(path string) ([]byte, error) {
+
+	if data, err := source.MemoryConfigSource.GetObject(path); err == nil {
+		return data, nil
+	}
+
+	return ioutil.ReadFile(filepath.Join(source.Dir, filepath.FromSlash(strings.TrimPrefix(path, "/"))))
+}
+
+// NewDocumentConfigSource reads a JSON document as written by regexuploader export
+func NewDocumentConfigSource(path string) (*MemoryConfigSource, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Version  int                                                     `json:"version"`
+		Props    map[string]map[string]map[string]map[string]interface{} `json:"props"`
+		Outputs  map[string]map[string]interface{}                       `json:"outputs"`
+		Lookups  map[string]map[string]interface{}                       `json:"lookups"`
+		Networks map[string]map[string]interface{}                       `json:"networks"`
+	}
+
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if document.Version != 1 {
+		return nil, fmt.Errorf("%s: version has to be 1", path)
+	}
+
+	source := NewMemoryConfigSource()
+
+	for sourcetype, sections := range document.Props {
+		for section, classes := range sections {
+			for class, attributes := range classes {
+				source.SetClass(sourcetype, section, class, attributes)
+			}
+		}
+	}
+
+	for output, attributes := range document.Outputs {
+		source.SetItem("conf/outputs/"+output+"/0", attributes)
+	}
+
+	for name, attributes := range document.Lookups {
+		source.SetItem("conf/lookups/"+name, attributes)
+	}
+
+	for name, attributes := range document.Networks {
+		source.SetItem("conf/networks/"+name, attributes)
+	}
+
+	return source, nil
+}
+
+// NewMemoryConfigSource creates an empty config source
+func NewMemoryConfigSource() *MemoryConfigSource {
+	return &MemoryConfigSource{Items: map[string]v3io.Item{}, Objects: map[string][]byte{}}
+}
+
+// SetItem sets the item at path, e.g. conf/outputs/hec/0
+func (source *MemoryConfigSource) SetItem(path string, item v3io.Item) {
+
+	source.lock.Lock()
+	defer source.lock.Unlock()
+
+	source.Items[strings.Trim(path, "/")] = item
+}
+
+// SetClass sets the item of class below /conf/props/<sourcetype>/<section>/, adding the class attribute
+func (source *MemoryConfigSource) SetClass(sourcetype string, section string, class string, item v3io.Item) {
+
+	classItem := v3io.Item{}
+	for name, value := range item {
+		classItem[name] = value
+	}
+
+	// Items carry their class like the ones written by regexuploader
+	classItem["class"] = class
+
+	source.SetItem("conf/props/"+sourcetype+"/"+section+"/"+class, classItem)
+}
+
+// SetObject sets the content of the object at path, e.g. a csv lookup
+func (source *MemoryConfigSource) SetObject(path string, data []byte) {
+
+	source.lock.Lock()
+	defer source.lock.Unlock()
+
+	source.Objects[strings.Trim(path, "/")] = data
+}
+
+// ListDirs returns the names of the directories below path
+func (source *MemoryConfigSource) ListDirs(path string) ([]string, error) {
+
+	source.lock.RLock()
+	defer source.lock.RUnlock()
+
+	prefix := strings.Trim(path, "/") + "/"
+
+	found := map[string]bool{}
+	for itemPath := range source.Items {
+		if rest := strings.TrimPrefix(itemPath, prefix); rest != itemPath && strings.Contains(rest, "/") {
+			found[rest[:strings.Index(rest, "/")]] = true
+		}
+	}
+
+	var dirs = make([]string, 0)
+	for dir := range found {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	return dirs, nil
+}
+
+// GetItems returns all items directly below path, sorted by path so the order of classes is the same on every run
+func (source *MemoryConfigSource) GetItems(path string) ([]v3io.Item, error) {
+
+	source.lock.RLock()
+	defer source.lock.RUnlock()
+
+	dir := strings.Trim(path, "/") + "/"
+
+	var itemPaths []string
+	for itemPath := range source.Items {
+		if strings.HasPrefix(itemPath, dir) && !strings.Contains(itemPath[len(dir):], "/") {
+			itemPaths = append(itemPaths, itemPath)
+		}
+	}
+	sort.Strings(itemPaths)
+
+	var items = make([]v3io.Item, 0)
+	for _, itemPath := range itemPaths {
+		items = append(items, source.Items[itemPath])
+	}
+
+	return items, nil
+}
+
+// GetItem returns the item at path
+func (source *MemoryConfigSource) GetItem(path string) (v3io.Item, error) {
+
+	source.lock.RLock()
+	defer source.lock.RUnlock()
+
+	item, ok := source.Items[strings.Trim(path, "/")]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", path)
+	}
+
+	return item, nil
+}
+
+// GetObject returns the content of the object at path
+func (source *MemoryConfigSource) GetObject(path string) ([]byte, error) {
+
+	source.lock.RLock()
+	defer source.lock.RUnlock()
+
+	data, ok := source.Objects[strings.Trim(path, "/")]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", path)
+	}
+
+	return data, nil
+}
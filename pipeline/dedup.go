@@ -0,0 +1,359 @@
+package pipeline
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v3io/v3io-go-http"
+)
+
+// DedupRule Struct
+type DedupRule struct {
+	Sourcetype string        `json:"sourcetype"`
+	Class      string        `json:"class"`
+	Fields     []string      `json:"fields"`
+	Window     time.Duration `json:"window"`
+	Table      string        `json:"table"`
+
+	cache *dedupCache
+}
+
+// Shared tables an expiry loop runs for, once per process, closing the channel stops it
+var dedupCleaners = struct {
+	sync.Mutex
+	tables map[string]chan struct{}
+}{tables: map[string]chan struct{}{}}
+
+// dedupCache is a LRU of event hashes and the time they were last seen
+type dedupCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// DedupStage Struct
+type DedupStage struct {
+	DedupRules []DedupRule
+
+	// Container for the shared dedup state of all replicas
+	Container *v3io.Container
+
+	// Shared tables whose expiry loop this stage started
+	cleaners []string
+}
+
+// Function to fetch dedup rules from /conf/props/<sourcetype>/dedup/
+func getDedupRules(source ConfigSource, logger Logger) []DedupRule {
+
+	var dedupRules = make([]DedupRule, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/dedup/", logger)
+
+		for item := range items {
+
+			dedupRule := DedupRule{Sourcetype: sourcetype, Window: 60 * time.Second}
+
+			dedupRule.Class, _ = items[item]["class"].(string)
+			dedupRule.Table, _ = items[item]["table"].(string)
+
+			// Events are identical if host, source and raw event are, unless fields are configured
+			dedupRule.Fields = []string{"host", "source", "event"}
+			if fields, ok := items[item]["fields"].(string); ok && fields != "" {
+				dedupRule.Fields = splitFieldList(fields)
+			}
+
+			if window, ok := getConfigInt(items[item]["window"]); ok {
+				dedupRule.Window = time.Duration(window) * time.Second
+			}
+
+			size := 10000
+			if value, ok := getConfigInt(items[item]["size"]); ok && value > 0 {
+				size = value
+			}
+
+			dedupRule.cache = newDedupCache(size)
+
+			dedupRules = append(dedupRules, dedupRule)
+		}
+	}
+
+	return dedupRules
+}
+
+func newDedupCache(size int) *dedupCache {
+	return &dedupCache{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// Seen records key as seen at now and reports whether it was already seen within window, the window slides, every
+// sighting extends it, so events repeating more often than the window are only passed once
+func (cache *dedupCache) Seen(key string, now time.Time, window time.Duration) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*dedupEntry)
+		cache.order.MoveToFront(element)
+
+		duplicate := now.Sub(entry.seen) < window
+		entry.seen = now
+
+		return duplicate
+	}
+
+	cache.entries[key] = cache.order.PushFront(&dedupEntry{key, now})
+
+	// Evict least recently seen entries
+	for cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*dedupEntry).key)
+	}
+
+	return false
+}
+
+// NewDedupStage creates the stage dropping duplicates of recently seen events and starts expiring the shared tables
+func NewDedupStage(config *Config, logger Logger) *DedupStage {
+
+	stage := &DedupStage{DedupRules: config.DedupRules, Container: config.Container}
+
+	if stage.Container == nil {
+		return stage
+	}
+
+	// Items are kept for the longest window of the rules sharing a table
+	windows := map[string]time.Duration{}
+	for _, dedupRule := range stage.DedupRules {
+		if dedupRule.Table != "" && dedupRule.Window > windows[dedupRule.Table] {
+			windows[dedupRule.Table] = dedupRule.Window
+		}
+	}
+
+	dedupCleaners.Lock()
+	defer dedupCleaners.Unlock()
+
+	for table, window := range windows {
+		if _, ok := dedupCleaners.tables[table]; !ok {
+			stop := make(chan struct{})
+			dedupCleaners.tables[table] = stop
+			stage.cleaners = append(stage.cleaners, table)
+			go runDedupCleaner(stage.Container, table, window, stop, logger)
+		}
+	}
+
+	return stage
+}
+
+// Close stops expiring the shared tables this stage started expiring
+func (stage *DedupStage) Close() {
+
+	dedupCleaners.Lock()
+	defer dedupCleaners.Unlock()
+
+	for _, table := range stage.cleaners {
+		close(dedupCleaners.tables[table])
+		delete(dedupCleaners.tables, table)
+	}
+
+	stage.cleaners = nil
+}
+
+// Process drops events already seen within the window
+func (stage *DedupStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if !getDedupedEvent(stage.DedupRules, stage.Container, logEvent, logger) {
+		logger.Debug("Event dropped as duplicate")
+		return nil, nil
+	}
+
+	return []LogEvent{logEvent}, nil
+}
+
+// Function to detect duplicates, returns false for events already seen within the window
+func getDedupedEvent(dedupRules []DedupRule, container *v3io.Container, logEvent LogEvent, logger Logger) bool {
+
+	for _, dedupRule := range dedupRules {
+
+		// Only apply rules of the event's sourcetype
+		if dedupRule.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		key := dedupRule.hash(logEvent)
+		now := time.Now()
+
+		duplicate := dedupRule.cache.Seen(key, now, dedupRule.Window)
+
+		// Ask the shared state for events not seen by this replica, duplicates extend the window there too
+		if dedupRule.Table != "" && container != nil {
+			if duplicate {
+				dedupRule.extendShared(container, key, now, logger)
+			} else {
+				duplicate = dedupRule.seenShared(container, key, now, logger)
+			}
+		}
+
+		if duplicate {
+			metrics.Inc("events_deduplicated_total", "sourcetype", logEvent.Sourcetype, "class", dedupRule.Class)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Function to hash the configured fields of an event
+func (dedupRule DedupRule) hash(logEvent LogEvent) string {
+
+	var values []string
+
+	for _, field := range dedupRule.Fields {
+		switch field {
+		case "host":
+			values = append(values, logEvent.Host)
+		case "source":
+			values = append(values, logEvent.Source)
+		case "sourcetype":
+			values = append(values, logEvent.Sourcetype)
+		case "index":
+			values = append(values, logEvent.Index)
+		case "time":
+			values = append(values, logEvent.Time)
+		case "event":
+			values = append(values, logEvent.Event)
+		default:
+			value, _ := getEventField(logEvent, field)
+			values = append(values, value)
+		}
+	}
+
+	hash := sha1.Sum([]byte(strings.Join(values, "\x00")))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Function to check and record a hash in the shared v3io KV table, the conditional update only succeeds for hashes
+// not seen within the window, so of replicas racing on the same event only one keeps it
+func (dedupRule DedupRule) seenShared(container *v3io.Container, key string, now time.Time, logger Logger) bool {
+
+	path := strings.TrimSuffix(dedupRule.Table, "/") + "/" + key
+
+	expression := fmt.Sprintf("seen=%d", now.Unix())
+
+	err := container.Sync.UpdateItem(&v3io.UpdateItemInput{
+		Path:       path,
+		Expression: &expression,
+		Condition:  fmt.Sprintf("not(exists(seen)) or seen<=%d", now.Add(-dedupRule.Window).Unix())})
+	if err == nil {
+		return false
+	}
+
+	// A failed condition is reported like any other error, the item tells them apart
+	GetItemResponse, GetItemerr := container.Sync.GetItem(&v3io.GetItemInput{
+		Path:           path,
+		AttributeNames: []string{"seen"}})
+	if GetItemerr == nil {
+		if seen, ok := getConfigInt(GetItemResponse.Output.(*v3io.GetItemOutput).Item["seen"]); ok && now.Sub(time.Unix(int64(seen), 0)) < dedupRule.Window {
+			dedupRule.extendShared(container, key, now, logger)
+			return true
+		}
+	}
+
+	logger.WarnWith("Dedup state update error", "path", path, "err", err)
+
+	return false
+}
+
+// Function to move the last sighting of a hash in the shared v3io KV table forward to now, sliding its window
+func (dedupRule DedupRule) extendShared(container *v3io.Container, key string, now time.Time, logger Logger) {
+
+	path := strings.TrimSuffix(dedupRule.Table, "/") + "/" + key
+
+	expression := fmt.Sprintf("seen=%d", now.Unix())
+
+	// Never moved back by a replica with an older sighting
+	err := container.Sync.UpdateItem(&v3io.UpdateItemInput{
+		Path:       path,
+		Expression: &expression,
+		Condition:  fmt.Sprintf("seen<%d", now.Unix())})
+	if err != nil {
+		logger.DebugWith("Dedup state extend error", "path", path, "err", err)
+	}
+}
+
+// Function to delete the items of a shared table whose window ended, every window but at most once a minute, until
+// stop is closed
+func runDedupCleaner(container *v3io.Container, table string, window time.Duration, stop chan struct{}, logger Logger) {
+
+	interval := window
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cleanDedupTable(container, table, window, logger)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Function to delete the items of a shared table seen before the window
+func cleanDedupTable(container *v3io.Container, table string, window time.Duration, logger Logger) {
+
+	table = strings.TrimSuffix(table, "/")
+
+	filter := fmt.Sprintf("seen<%d", time.Now().Add(-window).Unix())
+
+	// Set marker initially to empty
+	var marker string
+
+	for {
+		GetItemsResponse, GetItemserr := container.Sync.GetItems(&v3io.GetItemsInput{
+			Path:           table + "/",
+			AttributeNames: []string{"__name"},
+			Filter:         filter,
+			Limit:          1000,
+			Marker:         marker})
+		if GetItemserr != nil {
+			logger.WarnWith("Dedup state expiry error", "table", table, "err", GetItemserr)
+			return
+		}
+
+		GetItemsOutput := GetItemsResponse.Output.(*v3io.GetItemsOutput)
+
+		for _, item := range GetItemsOutput.Items {
+			name, _ := item["__name"].(string)
+			if name == "" {
+				continue
+			}
+			if err := container.Sync.DeleteObject(&v3io.DeleteObjectInput{Path: table + "/" + name}); err != nil {
+				logger.WarnWith("Dedup state expiry error", "path", table+"/"+name, "err", err)
+			}
+		}
+
+		if GetItemsOutput.Last {
+			return
+		}
+
+		marker = GetItemsOutput.NextMarker
+	}
+}
@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+)
+
+// DelimExtract Struct
+type DelimExtract struct {
+	Sourcetype string   `json:"sourcetype"`
+	Class      string   `json:"class"`
+	Delim      string   `json:"delim"`
+	Quote      string   `json:"quote"`
+	Escape     string   `json:"escape"`
+	Fields     []string `json:"fields"`
+}
+
+// Function to fetch delimiter based extractions from /conf/props/<sourcetype>/delims/
+func getDelimExtracts(source ConfigSource, logger Logger) []DelimExtract {
+
+	var delimExtracts = make([]DelimExtract, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/delims/", logger)
+
+		for item := range items {
+
+			delimExtract := DelimExtract{
+				Sourcetype: sourcetype,
+				Delim:      ",",
+				Quote:      `"`,
+				Escape:     `\`,
+			}
+
+			if class, ok := items[item]["class"].(string); ok {
+				delimExtract.Class = class
+			}
+
+			if delim, ok := items[item]["delim"].(string); ok && delim != "" {
+				delimExtract.Delim = unescapeDelim(delim)
+			}
+
+			// Quote and escape may be explicitly set to empty to disable them
+			if quote, ok := items[item]["quote"].(string); ok {
+				delimExtract.Quote = quote
+			}
+
+			if escape, ok := items[item]["escape"].(string); ok {
+				delimExtract.Escape = escape
+			}
+
+			if fields, ok := items[item]["fields"].(string); ok {
+				for _, field := range strings.Split(fields, ",") {
+					delimExtract.Fields = append(delimExtract.Fields, strings.TrimSpace(field))
+				}
+			}
+
+			if len(delimExtract.Fields) == 0 {
+				logger.WarnWith("Delim extract without fields", "sourcetype", sourcetype, "class", delimExtract.Class)
+				continue
+			}
+
+			delimExtracts = append(delimExtracts, delimExtract)
+		}
+	}
+
+	return delimExtracts
+}
+
+// Allow non printable delimiters to be stored in their escaped form
+func unescapeDelim(delim string) string {
+	switch delim {
+	case `\t`, "tab":
+		return "\t"
+	case "space":
+		return " "
+	case "pipe":
+		return "|"
+	}
+	return delim
+}
+
+// Function to split a delimited string honoring quotes and escaped delimiters, quotes and escapes
+func splitDelimited(str string, delim string, quote string, escape string) []string {
+
+	var values []string
+	var value bytes.Buffer
+
+	inQuotes := false
+
+	for i := 0; i < len(str); {
+		switch {
+		// Escaped delimiter, quote or escape, taken literally, other escapes are kept as in DOMAIN\user or C:\Windows
+		case escape != "" && strings.HasPrefix(str[i:], escape) && isEscaped(str[i+len(escape):], delim, quote, escape):
+			i += len(escape)
+			for _, special := range []string{delim, quote, escape} {
+				if special != "" && strings.HasPrefix(str[i:], special) {
+					value.WriteString(special)
+					i += len(special)
+					break
+				}
+			}
+		// Doubled quotes within quotes are a literal quote
+		case quote != "" && strings.HasPrefix(str[i:], quote):
+			if inQuotes && strings.HasPrefix(str[i+len(quote):], quote) {
+				value.WriteString(quote)
+				i += 2 * len(quote)
+			} else {
+				inQuotes = !inQuotes
+				i += len(quote)
+			}
+		case !inQuotes && strings.HasPrefix(str[i:], delim):
+			values = append(values, value.String())
+			value.Reset()
+			i += len(delim)
+		default:
+			value.WriteByte(str[i])
+			i++
+		}
+	}
+
+	return append(values, value.String())
+}
+
+// Function to check whether str starts with one of the characters an escape applies to
+func isEscaped(str string, delim string, quote string, escape string) bool {
+	return strings.HasPrefix(str, delim) || (quote != "" && strings.HasPrefix(str, quote)) || strings.HasPrefix(str, escape)
+}
+
+// Function to map delimited columns to their field names
+func doDelimMatch(delimExtract DelimExtract, str string) map[string]string {
+
+	values := splitDelimited(str, delimExtract.Delim, delimExtract.Quote, delimExtract.Escape)
+
+	// Nothing to extract if the event has no delimiters at all
+	if len(values) < 2 && len(delimExtract.Fields) > 1 {
+		return nil
+	}
+
+	subMatchMap := make(map[string]string)
+	for i, name := range delimExtract.Fields {
+		// Skip unnamed columns and columns missing in event
+		if name == "" || i >= len(values) {
+			continue
+		}
+		subMatchMap[name] = values[i]
+	}
+	return subMatchMap
+}
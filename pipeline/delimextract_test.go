@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitDelimited(t *testing.T) {
+
+	tests := []struct {
+		str    string
+		delim  string
+		quote  string
+		escape string
+		want   []string
+	}{
+		{"a,b,c", ",", `"`, `\`, []string{"a", "b", "c"}},
+		{"a,,c,", ",", `"`, `\`, []string{"a", "", "c", ""}},
+		{"", ",", `"`, `\`, []string{""}},
+
+		// Escapes apply to the delimiter, the quote and the escape only
+		{`a\,b,c`, ",", `"`, `\`, []string{"a,b", "c"}},
+		{`a\"b,c`, ",", `"`, `\`, []string{`a"b`, "c"}},
+		{`a\\,b`, ",", `"`, `\`, []string{`a\`, "b"}},
+		{`DOMAIN\user,C:\Windows\Temp`, ",", `"`, `\`, []string{`DOMAIN\user`, `C:\Windows\Temp`}},
+		{`a\`, ",", `"`, `\`, []string{`a\`}},
+
+		// Quotes
+		{`"a,b",c`, ",", `"`, `\`, []string{"a,b", "c"}},
+		{`"say ""hi""",x`, ",", `"`, `\`, []string{`say "hi"`, "x"}},
+		{`"DOMAIN\user",x`, ",", `"`, `\`, []string{`DOMAIN\user`, "x"}},
+		{`'a|b'|c`, "|", "'", "", []string{"a|b", "c"}},
+
+		// Without quote or escape characters
+		{`a\,b,"c"`, ",", "", "", []string{`a\`, "b", `"c"`}},
+
+		// Multi character delimiters
+		{"a::b::c", "::", "", "", []string{"a", "b", "c"}},
+		{"a\tb c", "\t", `"`, `\`, []string{"a", "b c"}},
+	}
+
+	for _, test := range tests {
+		if got := splitDelimited(test.str, test.delim, test.quote, test.escape); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitDelimited(%q, %q) = %q, want %q", test.str, test.delim, got, test.want)
+		}
+	}
+}
+
+func TestDoDelimMatch(t *testing.T) {
+
+	delimExtract := DelimExtract{Delim: ",", Quote: `"`, Escape: `\`, Fields: []string{"user", "", "action"}}
+
+	tests := []struct {
+		event string
+		want  map[string]string
+	}{
+		{`DOMAIN\user,skipped,Built`, map[string]string{"user": `DOMAIN\user`, "action": "Built"}},
+		{`Clark,x`, map[string]string{"user": "Clark"}},
+		{`no delimiters`, nil},
+	}
+
+	for _, test := range tests {
+		if got := doDelimMatch(delimExtract, test.event); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("doDelimMatch(%q) = %v, want %v", test.event, got, test.want)
+		}
+	}
+}
+
+func TestUnescapeDelim(t *testing.T) {
+
+	for delim, want := range map[string]string{`\t`: "\t", "tab": "\t", "space": " ", "pipe": "|", ";": ";"} {
+		if got := unescapeDelim(delim); got != want {
+			t.Errorf("unescapeDelim(%q) = %q, want %q", delim, got, want)
+		}
+	}
+}
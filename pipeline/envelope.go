@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"regexp"
+)
+
+// EnvelopeStage Struct
+type EnvelopeStage struct {
+	regex *regexp.Regexp
+}
+
+// Envelope put around raw events by the forwarders, time=...|meta=...|host=...|sourcetype=...|source=...|index=...|<event>
+const envelopeRegex = `time=(?P<time>.*?)\|meta=(?P<meta>.*?)\|host=(?P<host>.*?)\|sourcetype=(?P<sourcetype>.*?)\|source=(?P<source>.*?)\|index=(?P<index>.*?)\|(?P<event>.*?)$`
+
+// NewEnvelopeStage creates the stage unwrapping raw events from their envelope
+func NewEnvelopeStage() *EnvelopeStage {
+	return &EnvelopeStage{regex: regexp.MustCompile(envelopeRegex)}
+}
+
+// Process sets time, meta, host, sourcetype, source and index from the envelope, events without envelope are passed on unchanged
+func (stage *EnvelopeStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	fields := DoRegexMatch(stage.regex, logEvent.Event)
+	if fields == nil {
+		logger.DebugWith("Event without envelope", "event", logEvent.Event)
+		return []LogEvent{logEvent}, nil
+	}
+
+	logEvent.Time = fields["time"]
+	logEvent.Meta = fields["meta"]
+	logEvent.Host = fields["host"]
+	logEvent.Sourcetype = fields["sourcetype"]
+	logEvent.Source = fields["source"]
+	logEvent.Index = fields["index"]
+	logEvent.Event = fields["event"]
+
+	return []LogEvent{logEvent}, nil
+}
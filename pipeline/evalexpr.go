@@ -0,0 +1,835 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EvalExpression is a parsed eval expression, ready to be evaluated against event fields
+type EvalExpression struct {
+	Source string
+	root   evalNode
+}
+
+// Values are either nil (null), string, float64 or bool, regex arguments given as literal are a *regexp.Regexp
+type evalNode interface {
+	eval(fields map[string]string) interface{}
+}
+
+// evalFunction describes a function callable from expressions
+type evalFunction struct {
+	minArgs int
+	maxArgs int // -1 for variadic functions
+	call    func(args []interface{}) interface{}
+}
+
+var evalFunctions map[string]evalFunction
+
+func init() {
+	evalFunctions = map[string]evalFunction{
+		// String functions
+		"lower": {1, 1, func(args []interface{}) interface{} {
+			return mapString(args[0], strings.ToLower)
+		}},
+		"upper": {1, 1, func(args []interface{}) interface{} {
+			return mapString(args[0], strings.ToUpper)
+		}},
+		"len": {1, 1, func(args []interface{}) interface{} {
+			if args[0] == nil {
+				return nil
+			}
+			return float64(len([]rune(evalToString(args[0]))))
+		}},
+		"trim": {1, 2, func(args []interface{}) interface{} {
+			return trimString(strings.Trim, args)
+		}},
+		"ltrim": {1, 2, func(args []interface{}) interface{} {
+			return trimString(strings.TrimLeft, args)
+		}},
+		"rtrim": {1, 2, func(args []interface{}) interface{} {
+			return trimString(strings.TrimRight, args)
+		}},
+		"substr": {2, 3, evalSubstr},
+		"replace": {3, 3, func(args []interface{}) interface{} {
+			if args[0] == nil || args[1] == nil || args[2] == nil {
+				return nil
+			}
+			r, err := evalRegex(args[1])
+			if err != nil {
+				return nil
+			}
+			return r.ReplaceAllString(evalToString(args[0]), evalToString(args[2]))
+		}},
+		"match": {2, 2, func(args []interface{}) interface{} {
+			if args[0] == nil || args[1] == nil {
+				return nil
+			}
+			r, err := evalRegex(args[1])
+			if err != nil {
+				return nil
+			}
+			return r.MatchString(evalToString(args[0]))
+		}},
+		"tostring": {1, 1, func(args []interface{}) interface{} {
+			if args[0] == nil {
+				return nil
+			}
+			return evalToString(args[0])
+		}},
+
+		// Math functions
+		"tonumber": {1, 2, evalToNumber},
+		"abs": {1, 1, func(args []interface{}) interface{} {
+			return mapNumber(args[0], math.Abs)
+		}},
+		"floor": {1, 1, func(args []interface{}) interface{} {
+			return mapNumber(args[0], math.Floor)
+		}},
+		"ceil": {1, 1, func(args []interface{}) interface{} {
+			return mapNumber(args[0], math.Ceil)
+		}},
+		"round": {1, 2, evalRound},
+		"min":   {1, -1, func(args []interface{}) interface{} { return evalMinMax(args, -1) }},
+		"max":   {1, -1, func(args []interface{}) interface{} { return evalMinMax(args, 1) }},
+
+		// Conditional functions
+		"if": {3, 3, func(args []interface{}) interface{} {
+			if evalToBool(args[0]) {
+				return args[1]
+			}
+			return args[2]
+		}},
+		"case": {2, -1, func(args []interface{}) interface{} {
+			for i := 0; i+1 < len(args); i += 2 {
+				if evalToBool(args[i]) {
+					return args[i+1]
+				}
+			}
+			return nil
+		}},
+		"coalesce": {1, -1, func(args []interface{}) interface{} {
+			for _, arg := range args {
+				if arg != nil {
+					return arg
+				}
+			}
+			return nil
+		}},
+		"isnull": {1, 1, func(args []interface{}) interface{} {
+			return args[0] == nil
+		}},
+		"isnotnull": {1, 1, func(args []interface{}) interface{} {
+			return args[0] != nil
+		}},
+		"null": {0, 0, func(args []interface{}) interface{} {
+			return nil
+		}},
+	}
+}
+
+// Position of the regex argument of functions taking one, literal regexes are compiled while parsing
+var evalRegexArgs = map[string]int{"replace": 1, "match": 1}
+
+// ParseEvalExpression parses and validates an eval expression
+func ParseEvalExpression(source string) (*EvalExpression, error) {
+
+	tokens, err := lexEvalExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := evalParser{tokens: tokens}
+
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.peek().kind != evalTokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", parser.peek().text, parser.peek().pos)
+	}
+
+	return &EvalExpression{Source: source, root: root}, nil
+}
+
+// Eval evaluates the expression, returning nil if the result is null
+func (e *EvalExpression) Eval(fields map[string]string) interface{} {
+	return e.root.eval(fields)
+}
+
+//********************************
+// Lexer
+
+type evalTokenKind int
+
+const (
+	evalTokenEOF evalTokenKind = iota
+	evalTokenNumber
+	evalTokenString
+	evalTokenIdent
+	evalTokenField
+	evalTokenOperator
+)
+
+type evalToken struct {
+	kind evalTokenKind
+	text string
+	pos  int
+}
+
+// Multi character operators have to be listed before their prefixes
+var evalOperators = []string{"==", "!=", "<=", ">=", "<", ">", "=", "!", "+", "-", "*", "/", "%", ".", "(", ")", ","}
+
+func lexEvalExpression(source string) ([]evalToken, error) {
+
+	var tokens []evalToken
+
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, evalToken{evalTokenNumber, string(runes[start:i]), start})
+
+		// Double quotes for string literals, single quotes for field names with special characters
+		case c == '"' || c == '\'':
+			start := i
+			var text []rune
+			for i++; i < len(runes) && runes[i] != c; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text = append(text, runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated quote at position %d", start)
+			}
+			i++
+			kind := evalTokenString
+			if c == '\'' {
+				kind = evalTokenField
+			}
+			tokens = append(tokens, evalToken{kind, string(text), start})
+
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			// Dots are the concatenation operator, field names containing dots have to be quoted
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_:", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, evalToken{evalTokenIdent, string(runes[start:i]), start})
+
+		default:
+			found := false
+			for _, operator := range evalOperators {
+				if strings.HasPrefix(string(runes[i:]), operator) {
+					tokens = append(tokens, evalToken{evalTokenOperator, operator, i})
+					i += len([]rune(operator))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, evalToken{evalTokenEOF, "end of expression", len(runes)}), nil
+}
+
+//********************************
+// Parser
+
+type evalParser struct {
+	tokens []evalToken
+	pos    int
+}
+
+func (p *evalParser) peek() evalToken {
+	return p.tokens[p.pos]
+}
+
+func (p *evalParser) next() evalToken {
+	token := p.tokens[p.pos]
+	if token.kind != evalTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// Keywords are matched case insensitive like in Splunk
+func (p *evalParser) acceptKeyword(keyword string) bool {
+	if p.peek().kind == evalTokenIdent && strings.EqualFold(p.peek().text, keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *evalParser) acceptOperator(operators ...string) (string, bool) {
+	if p.peek().kind == evalTokenOperator {
+		for _, operator := range operators {
+			if p.peek().text == operator {
+				p.next()
+				return operator, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *evalParser) expectOperator(operator string) error {
+	if _, ok := p.acceptOperator(operator); !ok {
+		return fmt.Errorf("expected %q at position %d, got %q", operator, p.peek().pos, p.peek().text)
+	}
+	return nil
+}
+
+func (p *evalParser) parseOr() (evalNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &evalBinaryNode{"OR", left, right}
+	}
+	return left, nil
+}
+
+func (p *evalParser) parseAnd() (evalNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &evalBinaryNode{"AND", left, right}
+	}
+	return left, nil
+}
+
+func (p *evalParser) parseNot() (evalNode, error) {
+	_, negate := p.acceptOperator("!")
+	if negate || p.acceptKeyword("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &evalNotNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *evalParser) parseComparison() (evalNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if operator, ok := p.acceptOperator("==", "=", "!=", "<=", ">=", "<", ">"); ok {
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if operator == "=" {
+			operator = "=="
+		}
+		left = &evalBinaryNode{operator, left, right}
+	}
+	return left, nil
+}
+
+func (p *evalParser) parseAdditive() (evalNode, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.acceptOperator("+", "-", ".")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &evalBinaryNode{operator, left, right}
+	}
+}
+
+func (p *evalParser) parseMultiplicative() (evalNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.acceptOperator("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &evalBinaryNode{operator, left, right}
+	}
+}
+
+func (p *evalParser) parseUnary() (evalNode, error) {
+	if _, ok := p.acceptOperator("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &evalBinaryNode{"-", &evalLiteralNode{float64(0)}, operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *evalParser) parsePrimary() (evalNode, error) {
+	token := p.next()
+
+	switch token.kind {
+	case evalTokenNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", token.text, token.pos)
+		}
+		return &evalLiteralNode{number}, nil
+
+	case evalTokenString:
+		return &evalLiteralNode{token.text}, nil
+
+	case evalTokenField:
+		return &evalFieldNode{token.text}, nil
+
+	case evalTokenIdent:
+		// Identifiers followed by a parenthesis are function calls, null() included
+		if _, ok := p.acceptOperator("("); ok {
+			return p.parseCall(token)
+		}
+
+		switch strings.ToLower(token.text) {
+		case "true":
+			return &evalLiteralNode{true}, nil
+		case "false":
+			return &evalLiteralNode{false}, nil
+		case "null":
+			return &evalLiteralNode{nil}, nil
+		}
+		return &evalFieldNode{token.text}, nil
+
+	case evalTokenOperator:
+		if token.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expectOperator(")")
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", token.text, token.pos)
+}
+
+func (p *evalParser) parseCall(name evalToken) (evalNode, error) {
+
+	function, ok := evalFunctions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+
+	var args []evalNode
+
+	if _, ok := p.acceptOperator(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if _, ok := p.acceptOperator(","); !ok {
+				break
+			}
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) < function.minArgs || (function.maxArgs >= 0 && len(args) > function.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments for %s() at position %d", name.text, name.pos)
+	}
+
+	// Literal regexes are compiled once, invalid ones fail the expression instead of every event
+	if i, ok := evalRegexArgs[strings.ToLower(name.text)]; ok {
+		if literal, ok := args[i].(*evalLiteralNode); ok {
+			if regex, ok := literal.value.(string); ok {
+				r, err := regexp.Compile(regex)
+				if err != nil {
+					return nil, fmt.Errorf("invalid regex for %s() at position %d: %v", name.text, name.pos, err)
+				}
+				args[i] = &evalLiteralNode{r}
+			}
+		}
+	}
+
+	return &evalCallNode{function, args}, nil
+}
+
+//********************************
+// Nodes
+
+type evalLiteralNode struct {
+	value interface{}
+}
+
+func (n *evalLiteralNode) eval(fields map[string]string) interface{} {
+	return n.value
+}
+
+type evalFieldNode struct {
+	name string
+}
+
+// Fields are looked up as named, or with the prefix added by Field-Prefix-Mode
+func (n *evalFieldNode) eval(fields map[string]string) interface{} {
+	if value, ok := fields[n.name]; ok {
+		return value
+	}
+	if value, ok := fields["nuclio."+n.name]; ok {
+		return value
+	}
+	return nil
+}
+
+type evalNotNode struct {
+	operand evalNode
+}
+
+func (n *evalNotNode) eval(fields map[string]string) interface{} {
+	return !evalToBool(n.operand.eval(fields))
+}
+
+type evalCallNode struct {
+	function evalFunction
+	args     []evalNode
+}
+
+func (n *evalCallNode) eval(fields map[string]string) interface{} {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(fields)
+	}
+	return n.function.call(args)
+}
+
+type evalBinaryNode struct {
+	operator string
+	left     evalNode
+	right    evalNode
+}
+
+func (n *evalBinaryNode) eval(fields map[string]string) interface{} {
+
+	left := n.left.eval(fields)
+
+	// Short circuit boolean operators
+	switch n.operator {
+	case "AND":
+		return evalToBool(left) && evalToBool(n.right.eval(fields))
+	case "OR":
+		return evalToBool(left) || evalToBool(n.right.eval(fields))
+	}
+
+	right := n.right.eval(fields)
+
+	switch n.operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return evalCompare(n.operator, left, right)
+	}
+
+	// Null propagates through arithmetic and concatenation
+	if left == nil || right == nil {
+		return nil
+	}
+
+	if n.operator == "." {
+		return evalToString(left) + evalToString(right)
+	}
+
+	leftNumber, leftOk := evalNumber(left)
+	rightNumber, rightOk := evalNumber(right)
+
+	if !leftOk || !rightOk {
+		// Adding strings concatenates them
+		if n.operator == "+" {
+			return evalToString(left) + evalToString(right)
+		}
+		return nil
+	}
+
+	switch n.operator {
+	case "+":
+		return leftNumber + rightNumber
+	case "-":
+		return leftNumber - rightNumber
+	case "*":
+		return leftNumber * rightNumber
+	case "/":
+		if rightNumber == 0 {
+			return nil
+		}
+		return leftNumber / rightNumber
+	case "%":
+		if rightNumber == 0 {
+			return nil
+		}
+		return math.Mod(leftNumber, rightNumber)
+	}
+
+	return nil
+}
+
+//********************************
+// Helpers
+
+func evalCompare(operator string, left interface{}, right interface{}) interface{} {
+
+	if left == nil || right == nil {
+		switch operator {
+		case "==":
+			return left == right
+		case "!=":
+			return left != right
+		}
+		return false
+	}
+
+	var compare int
+
+	leftNumber, leftOk := evalNumber(left)
+	rightNumber, rightOk := evalNumber(right)
+
+	// Compare numerically if possible, lexically otherwise
+	if leftOk && rightOk {
+		switch {
+		case leftNumber < rightNumber:
+			compare = -1
+		case leftNumber > rightNumber:
+			compare = 1
+		}
+	} else {
+		compare = strings.Compare(evalToString(left), evalToString(right))
+	}
+
+	switch operator {
+	case "==":
+		return compare == 0
+	case "!=":
+		return compare != 0
+	case "<":
+		return compare < 0
+	case "<=":
+		return compare <= 0
+	case ">":
+		return compare > 0
+	}
+	return compare >= 0
+}
+
+func evalNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func evalToString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func evalToBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+func mapString(value interface{}, function func(string) string) interface{} {
+	if value == nil {
+		return nil
+	}
+	return function(evalToString(value))
+}
+
+func mapNumber(value interface{}, function func(float64) float64) interface{} {
+	number, ok := evalNumber(value)
+	if !ok {
+		return nil
+	}
+	return function(number)
+}
+
+func trimString(function func(string, string) string, args []interface{}) interface{} {
+	if args[0] == nil {
+		return nil
+	}
+	cutset := " \t"
+	if len(args) > 1 && args[1] != nil {
+		cutset = evalToString(args[1])
+	}
+	return function(evalToString(args[0]), cutset)
+}
+
+// substr(str, start, length) with a 1-based start, negative starts count from the end
+func evalSubstr(args []interface{}) interface{} {
+	if args[0] == nil {
+		return nil
+	}
+
+	runes := []rune(evalToString(args[0]))
+
+	start, ok := evalNumber(args[1])
+	if !ok {
+		return nil
+	}
+
+	from := int(start) - 1
+	if start < 0 {
+		from = len(runes) + int(start)
+	}
+	if from < 0 {
+		from = 0
+	}
+	if from > len(runes) {
+		from = len(runes)
+	}
+
+	to := len(runes)
+	if len(args) > 2 {
+		length, ok := evalNumber(args[2])
+		if !ok {
+			return nil
+		}
+		if from+int(length) < to {
+			to = from + int(length)
+		}
+	}
+	if to < from {
+		to = from
+	}
+
+	return string(runes[from:to])
+}
+
+// tonumber(str, base) supports bases 2 to 36, default is decimal
+func evalToNumber(args []interface{}) interface{} {
+	if args[0] == nil {
+		return nil
+	}
+
+	if len(args) > 1 {
+		base, ok := evalNumber(args[1])
+		if !ok {
+			return nil
+		}
+		number, err := strconv.ParseInt(strings.TrimSpace(evalToString(args[0])), int(base), 64)
+		if err != nil {
+			return nil
+		}
+		return float64(number)
+	}
+
+	number, ok := evalNumber(args[0])
+	if !ok {
+		return nil
+	}
+	return number
+}
+
+// round(number, digits) rounds half away from zero
+func evalRound(args []interface{}) interface{} {
+	number, ok := evalNumber(args[0])
+	if !ok {
+		return nil
+	}
+
+	digits := float64(0)
+	if len(args) > 1 {
+		if digits, ok = evalNumber(args[1]); !ok {
+			return nil
+		}
+	}
+
+	factor := math.Pow(10, digits)
+	if number < 0 {
+		return -math.Floor(-number*factor+0.5) / factor
+	}
+	return math.Floor(number*factor+0.5) / factor
+}
+
+// Numeric arguments are compared numerically, otherwise lexically, nulls are ignored
+func evalMinMax(args []interface{}, sign int) interface{} {
+	operator := "<"
+	if sign > 0 {
+		operator = ">"
+	}
+
+	var result interface{}
+	for _, arg := range args {
+		if arg == nil {
+			continue
+		}
+		if result == nil || evalCompare(operator, arg, result) == true {
+			result = arg
+		}
+	}
+	return result
+}
+
+// Function to get the regex of a regex argument, regexes built from fields are compiled on every call
+func evalRegex(value interface{}) (*regexp.Regexp, error) {
+	if r, ok := value.(*regexp.Regexp); ok {
+		return r, nil
+	}
+	return regexp.Compile(evalToString(value))
+}
@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+var evalTestFields = map[string]string{
+	"a":            "foo",
+	"b":            "bar",
+	"bytes":        "2048",
+	"count":        "3",
+	"empty":        "",
+	"action":       "Built",
+	"nuclio.user":  "Clark",
+	"cs.uri":       "/index.html",
+	"src_ip":       "10.0.0.1",
+	"mixed:colons": "yes",
+}
+
+func TestEvalExpression(t *testing.T) {
+
+	tests := []struct {
+		expression string
+		want       interface{}
+	}{
+		// Precedence
+		{"1 + 2 * 3", float64(7)},
+		{"(1 + 2) * 3", float64(9)},
+		{"10 - 4 - 3", float64(3)},
+		{"-2 * 3", float64(-6)},
+		{"7 % 4 + 1", float64(4)},
+		{"1 + 1 == 2", true},
+		{"1 < 2 AND 2 < 1 OR true", true},
+		{"NOT 1 == 2 AND false", false},
+		{"!false", true},
+
+		// Concatenation
+		{"a.b", "foobar"},
+		{"a . b", "foobar"},
+		{`a . "-" . count`, "foo-3"},
+		{"a + b", "foobar"},
+		{"count . 1", "31"},
+		{"'cs.uri'", "/index.html"},
+		{"missing . a", nil},
+
+		// Fields
+		{"user", "Clark"},
+		{"bytes / 1024", float64(2)},
+		{"mixed:colons", "yes"},
+		{"isnull(missing)", true},
+		{"isnotnull(empty)", true},
+
+		// Functions
+		{"lower(action)", "built"},
+		{"UPPER(a)", "FOO"},
+		{"len(src_ip)", float64(8)},
+		{`trim("  x  ")`, "x"},
+		{`substr(src_ip, 1, 2)`, "10"},
+		{`substr(src_ip, -3)`, "0.1"},
+		{`replace(src_ip, "\\.", "-")`, "10-0-0-1"},
+		{`match(action, "(?i)built|permit")`, true},
+		{`match(action, b)`, false},
+		{`tonumber("ff", 16)`, float64(255)},
+		{"round(bytes / 1000, 1)", float64(2)},
+		{"round(-2.5)", float64(-3)},
+		{"min(count, 2, 5)", float64(2)},
+		{`max("a", "c", "b")`, "c"},
+		{`if(count > 2, "many", "few")`, "many"},
+		{`case(action == "Deny", "blocked", match(action, "uilt"), "allowed", true, "other")`, "allowed"},
+		{"coalesce(missing, null(), b)", "bar"},
+		{"bytes / 0", nil},
+	}
+
+	for _, test := range tests {
+
+		expression, err := ParseEvalExpression(test.expression)
+		if err != nil {
+			t.Errorf("%s: %v", test.expression, err)
+			continue
+		}
+
+		if got := expression.Eval(evalTestFields); got != test.want {
+			t.Errorf("%s = %#v, want %#v", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestEvalExpressionErrors(t *testing.T) {
+
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"1 +", "unexpected"},
+		{"(1 + 2", `expected ")"`},
+		{"1 2", "unexpected"},
+		{`"unterminated`, "unterminated quote"},
+		{"a # b", "unexpected character"},
+		{"nosuch(a)", "unknown function"},
+		{"lower(a, b)", "wrong number of arguments"},
+		{"if(a, b)", "wrong number of arguments"},
+		{`match(a, "(")`, "invalid regex for match()"},
+		{`replace(a, "[", "")`, "invalid regex for replace()"},
+	}
+
+	for _, test := range tests {
+
+		_, err := ParseEvalExpression(test.expression)
+		if err == nil {
+			t.Errorf("%s: no error, want %q", test.expression, test.err)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %q, want %q", test.expression, err, test.err)
+		}
+	}
+}
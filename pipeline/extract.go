@@ -0,0 +1,283 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RegexExtract Struct
+type RegexExtract struct {
+	Sourcetype string `json:"sourcetype"`
+	Class      string `json:"class"`
+	Regex      string `json:"regex"`
+	MultiMatch bool   `json:"multimatch"`
+}
+
+// ExtractStage Struct
+type ExtractStage struct {
+	RegexExtracts []RegexExtract
+	DelimExtracts []DelimExtract
+}
+
+// MetaStage Struct
+type MetaStage struct{}
+
+// FormatStage Struct
+type FormatStage struct{}
+
+// Function to fetch regex extractions from /conf/props/<sourcetype>/extract/, classes without class name or regex
+// string are errors
+func getRegexExtracts(source ConfigSource, logger Logger) ([]RegexExtract, error) {
+
+	// Define slice for regexExtracts
+	var regexExtracts = make([]RegexExtract, 0)
+
+	// Loop over Regex Classes of all sourcetypes
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/extract/", logger)
+
+		for item := range items {
+
+			class, ok := items[item]["class"].(string)
+			if !ok {
+				return nil, fmt.Errorf("extract class of %s without class name", sourcetype)
+			}
+
+			regex, ok := items[item]["regex"].(string)
+			if !ok {
+				return nil, fmt.Errorf("extract class %s of %s has no regex string", class, sourcetype)
+			}
+
+			// Multi match extractions capture every occurrence of the regex
+			multiMatch := getConfigBool(items[item]["multimatch"])
+
+			regexExtracts = append(regexExtracts, RegexExtract{sourcetype, class, regex, multiMatch})
+
+		}
+	}
+
+	return regexExtracts, nil
+
+}
+
+// NewExtractStage creates the stage extracting fields with regexes and delimiters
+func NewExtractStage(config *Config) *ExtractStage {
+	return &ExtractStage{RegexExtracts: config.RegexExtracts, DelimExtracts: config.DelimExtracts}
+}
+
+// Process fetches fields from event
+func (stage *ExtractStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+	return []LogEvent{getEventFields(stage.RegexExtracts, stage.DelimExtracts, logEvent, logger)}, nil
+}
+
+// Function to add event fields to field list, counting the hits and misses of every extract class
+func getEventFields(regexExtracts []RegexExtract, delimExtracts []DelimExtract, logEvent LogEvent, logger Logger) LogEvent {
+	return extractEventFields(regexExtracts, delimExtracts, logEvent, true, logger)
+}
+
+// Function to add event fields to field list, uncounted for extractions done again by the extract stage
+func extractEventFields(regexExtracts []RegexExtract, delimExtracts []DelimExtract, logEvent LogEvent, count bool, logger Logger) LogEvent {
+
+	regexFoundFlag := false
+
+	for i := range regexExtracts {
+		if regexExtracts[i].Sourcetype == logEvent.Sourcetype {
+			regexFoundFlag = true
+		}
+	}
+
+	for i := range delimExtracts {
+		if delimExtracts[i].Sourcetype == logEvent.Sourcetype {
+			regexFoundFlag = true
+		}
+	}
+
+	// Nothing to do if regex is not found for event
+	if regexFoundFlag == false {
+		return logEvent
+	}
+
+	var fields map[string]string
+
+	for _, regexExtract := range regexExtracts {
+
+		// Only apply regexes of the event's sourcetype
+		if regexExtract.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		//logger.Debug("Event Regex Extract Name: %v", regexExtract.Class)
+		//logger.Debug("Event Regex Extract Regex: %v", regexExtract.Regex)
+
+		// Compiling regex
+		r, err := regexp.Compile(regexExtract.Regex)
+
+		// Catching regex errors
+		if err != nil {
+			logger.Error("Regex Error:", regexExtract.Regex)
+			continue
+		}
+
+		start := time.Now()
+
+		// Running Regex over
+		if regexExtract.MultiMatch {
+			multiFields := DoRegexMatchAll(r, logEvent.Event)
+			if count {
+				countExtraction(regexExtract.Sourcetype, regexExtract.Class, multiFields != nil, start)
+			}
+			logEvent = addEventMultiFields(logEvent, multiFields)
+			continue
+		}
+
+		fields = DoRegexMatch(r, logEvent.Event)
+		if count {
+			countExtraction(regexExtract.Sourcetype, regexExtract.Class, fields != nil, start)
+		}
+		//logger.Debug("Fields: %s", fields)
+
+		logEvent = addEventFields(logEvent, fields)
+
+	}
+
+	for _, delimExtract := range delimExtracts {
+
+		// Only apply delimiter extractions of the event's sourcetype
+		if delimExtract.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		start := time.Now()
+
+		// Splitting event into columns
+		fields = doDelimMatch(delimExtract, logEvent.Event)
+		if count {
+			countExtraction(delimExtract.Sourcetype, delimExtract.Class, fields != nil, start)
+		}
+
+		logEvent = addEventFields(logEvent, fields)
+	}
+
+	return logEvent
+}
+
+// Function to count a hit or miss of an extract class and the time spent matching since start
+func countExtraction(sourcetype string, class string, hit bool, start time.Time) {
+
+	metrics.Observe("extraction_regex_seconds", time.Since(start).Seconds(), "sourcetype", sourcetype, "class", class)
+
+	if hit {
+		metrics.Inc("extraction_hits_total", "sourcetype", sourcetype, "class", class)
+	} else {
+		metrics.Inc("extraction_misses_total", "sourcetype", sourcetype, "class", class)
+	}
+}
+
+// NewMetaStage creates the stage adding internal fields from the meta element
+func NewMetaStage() *MetaStage {
+	return &MetaStage{}
+}
+
+// Process adds meta fields to field list
+func (stage *MetaStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+	return []LogEvent{getMetaFields(logEvent, logger)}, nil
+}
+
+// Function to add meta fields to field list
+func getMetaFields(logEvent LogEvent, logger Logger) LogEvent {
+
+	var fields map[string]string
+
+	// Define regexes for internal fields
+	regexExtracts := make([]RegexExtract, 0)
+	regexExtracts = append(regexExtracts, RegexExtract{Class: "_subsecond", Regex: "_subsecond::(?P<_subsecond>\\S+)"})
+	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_second", Regex: "date_second::(?P<date_second>\\d+)"})
+	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_hour", Regex: "date_hour::(?P<date_hour>\\d+)"})
+	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_year", Regex: "date_year::(?P<date_second>\\d+)"})
+	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_month", Regex: "date_month::(?P<date_month>\\w+)"})
+	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_wday", Regex: "date_wday::(?P<date_wday>\\w+)"})
+	regexExtracts = append(regexExtracts, RegexExtract{Class: "date_zone", Regex: "date_zone::(?P<date_zone>\\w+)"})
+
+	for _, regexExtract := range regexExtracts {
+		//logger.Debug("Meta Regex Extract Name: %v", regexExtract.Class)
+		//logger.Debug("Meta Regex Extract Regex: %v", regexExtract.Regex)
+
+		// Compiling regex
+		r, err := regexp.Compile(regexExtract.Regex)
+
+		// Catching regex errors
+		if err != nil {
+			logger.Error("Regex Error:", regexExtract.Regex)
+		}
+
+		fields = DoRegexMatch(r, logEvent.Meta)
+		//logger.Debug("Fields: %s", fields)
+
+		if fields != nil {
+			for key, value := range fields {
+				logEvent.Fields[key] = value
+			}
+
+			//logger.Debug("logEvent: %s", logEvent)
+		}
+	}
+	return logEvent
+
+}
+
+// NewFormatStage creates the stage rewriting the event according to its output mode
+func NewFormatStage() *FormatStage {
+	return &FormatStage{}
+}
+
+// Process rewrites the event according to its output mode
+func (stage *FormatStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+	return []LogEvent{formatEvent(logEvent, logEvent.OutputMode, logger)}, nil
+}
+
+// Function to rewrite the event according to the output mode
+func formatEvent(logEvent LogEvent, eventOutputMode string, logger Logger) LogEvent {
+
+	// Output only segments, drop segmenter characters
+
+	if eventOutputMode == "minimal" {
+		logEvent.Event = ""
+		for _, value := range logEvent.Fields {
+			logEvent.Event = value + " " + logEvent.Event
+		}
+		for _, values := range logEvent.MultiFields {
+			for _, value := range values {
+				logEvent.Event = value + " " + logEvent.Event
+			}
+		}
+
+		segmentersRegex := `[^A-Za-z0-9]`
+
+		r, err := regexp.Compile(segmentersRegex)
+
+		// Catchin regex errors
+		if err != nil {
+			logger.Error("Regex Error:", segmentersRegex)
+		}
+
+		logEvent.Event = r.ReplaceAllString(logEvent.Event, " ")
+	} else if eventOutputMode == "kv" {
+		logEvent.Event = ""
+		for key, value := range logEvent.Fields {
+			logEvent.Event = key + "=\"" + value + "\" " + logEvent.Event
+
+		}
+		// Repeated keys are merged into a multivalue field by Splunk
+		for key, values := range logEvent.MultiFields {
+			for i := len(values) - 1; i >= 0; i-- {
+				logEvent.Event = key + "=\"" + values[i] + "\" " + logEvent.Event
+			}
+		}
+	} else if eventOutputMode == "none" {
+		logEvent.Event = "-"
+	}
+
+	return logEvent
+}
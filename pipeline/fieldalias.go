@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// FieldAlias Struct
+type FieldAlias struct {
+	Sourcetype string `json:"sourcetype"`
+	Class      string `json:"class"`
+	Field      string `json:"field"`
+	Alias      string `json:"alias"`
+	Rename     bool   `json:"rename"`
+}
+
+// FieldFilter Struct
+type FieldFilter struct {
+	Sourcetype string   `json:"sourcetype"`
+	Class      string   `json:"class"`
+	Keep       []string `json:"keep"`
+	Drop       []string `json:"drop"`
+}
+
+// NormalizeStage Struct
+type NormalizeStage struct {
+	FieldAliases []FieldAlias
+	FieldFilters []FieldFilter
+}
+
+// Function to fetch field aliases from /conf/props/<sourcetype>/alias/
+func getFieldAliases(source ConfigSource, logger Logger) []FieldAlias {
+
+	var fieldAliases = make([]FieldAlias, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/alias/", logger)
+
+		for item := range items {
+
+			fieldAlias := FieldAlias{Sourcetype: sourcetype}
+
+			fieldAlias.Class, _ = items[item]["class"].(string)
+			fieldAlias.Field, _ = items[item]["field"].(string)
+			fieldAlias.Alias, _ = items[item]["alias"].(string)
+
+			// Renames drop the original field, aliases keep it
+			fieldAlias.Rename = getConfigBool(items[item]["rename"])
+
+			if fieldAlias.Field == "" || fieldAlias.Alias == "" {
+				logger.ErrorWith("Field alias incomplete", "sourcetype", sourcetype, "class", fieldAlias.Class)
+				continue
+			}
+
+			fieldAliases = append(fieldAliases, fieldAlias)
+		}
+	}
+
+	sort.SliceStable(fieldAliases, func(i, j int) bool {
+		return fieldAliases[i].Class < fieldAliases[j].Class
+	})
+
+	return fieldAliases
+}
+
+// Function to fetch field allow and deny lists from /conf/props/<sourcetype>/fields/
+func getFieldFilters(source ConfigSource, logger Logger) []FieldFilter {
+
+	var fieldFilters = make([]FieldFilter, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/fields/", logger)
+
+		for item := range items {
+
+			fieldFilter := FieldFilter{Sourcetype: sourcetype}
+
+			fieldFilter.Class, _ = items[item]["class"].(string)
+
+			if keep, ok := items[item]["keep"].(string); ok {
+				fieldFilter.Keep = splitFieldList(keep)
+			}
+
+			if drop, ok := items[item]["drop"].(string); ok {
+				fieldFilter.Drop = splitFieldList(drop)
+			}
+
+			fieldFilters = append(fieldFilters, fieldFilter)
+		}
+	}
+
+	return fieldFilters
+}
+
+// Function to split a comma separated field list, skipping empty entries
+func splitFieldList(list string) []string {
+
+	var fields []string
+
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// NewNormalizeStage creates the stage normalizing field names
+func NewNormalizeStage(config *Config) *NormalizeStage {
+	return &NormalizeStage{FieldAliases: config.FieldAliases, FieldFilters: config.FieldFilters}
+}
+
+// Process applies field aliases, renames and allow and deny lists
+func (stage *NormalizeStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	for _, fieldAlias := range stage.FieldAliases {
+
+		// Only apply aliases of the event's sourcetype
+		if fieldAlias.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		alias := fieldAlias.Alias
+		if logEvent.PrefixFields {
+			alias = FieldPrefix + alias
+		}
+
+		// Fields are looked up as named, or with the prefix added by Field-Prefix-Mode
+		for _, field := range []string{fieldAlias.Field, FieldPrefix + fieldAlias.Field} {
+
+			if field == alias {
+				continue
+			}
+
+			if value, ok := logEvent.Fields[field]; ok {
+				logEvent.Fields[alias] = value
+				delete(logEvent.MultiFields, alias)
+			} else if values, ok := logEvent.MultiFields[field]; ok {
+				logEvent.MultiFields[alias] = values
+				delete(logEvent.Fields, alias)
+			} else {
+				continue
+			}
+
+			if fieldAlias.Rename {
+				delete(logEvent.Fields, field)
+				delete(logEvent.MultiFields, field)
+			}
+			break
+		}
+	}
+
+	// Allow and deny lists only cover the fields extracted so far, enrichment stages run after normalize
+	for _, fieldFilter := range stage.FieldFilters {
+
+		// Only apply filters of the event's sourcetype
+		if fieldFilter.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		for field := range logEvent.Fields {
+			if !keepField(fieldFilter, field) {
+				delete(logEvent.Fields, field)
+			}
+		}
+
+		for field := range logEvent.MultiFields {
+			if !keepField(fieldFilter, field) {
+				delete(logEvent.MultiFields, field)
+			}
+		}
+	}
+
+	return []LogEvent{logEvent}, nil
+}
+
+// Function to check a field against allow and deny lists, the deny list wins
+func keepField(fieldFilter FieldFilter, field string) bool {
+
+	if matchFieldList(fieldFilter.Drop, field) {
+		return false
+	}
+
+	// Without allow list all fields are kept
+	return len(fieldFilter.Keep) == 0 || matchFieldList(fieldFilter.Keep, field)
+}
+
+// Function to match a field against wildcard patterns, with or without the nuclio prefix
+func matchFieldList(patterns []string, field string) bool {
+
+	unprefixed := strings.TrimPrefix(field, FieldPrefix)
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, field); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, unprefixed); matched {
+			return true
+		}
+	}
+
+	return false
+}
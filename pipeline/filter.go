@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"regexp"
+)
+
+// FilterRule Struct
+type FilterRule struct {
+	Sourcetype string `json:"sourcetype"`
+	Class      string `json:"class"`
+	Action     string `json:"action"`
+	Field      string `json:"field"`
+	Regex      string `json:"regex"`
+
+	regex *regexp.Regexp
+}
+
+// FilterStage Struct
+type FilterStage struct {
+	FilterRules []FilterRule
+}
+
+// Function to fetch filter rules from /conf/props/<sourcetype>/filter/
+func getFilterRules(source ConfigSource, logger Logger) []FilterRule {
+
+	var filterRules = make([]FilterRule, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/filter/", logger)
+
+		for item := range items {
+
+			filterRule := FilterRule{Sourcetype: sourcetype, Action: "exclude"}
+
+			filterRule.Class, _ = items[item]["class"].(string)
+			filterRule.Field, _ = items[item]["field"].(string)
+			filterRule.Regex, _ = items[item]["regex"].(string)
+
+			if action, ok := items[item]["action"].(string); ok && action != "" {
+				filterRule.Action = action
+			}
+
+			if filterRule.Action != "include" && filterRule.Action != "exclude" {
+				logger.ErrorWith("Filter rule action unknown", "sourcetype", sourcetype, "class", filterRule.Class, "action", filterRule.Action)
+				continue
+			}
+
+			r, err := regexp.Compile(filterRule.Regex)
+			if err != nil || filterRule.Regex == "" {
+				logger.ErrorWith("Filter rule regex error", "sourcetype", sourcetype, "class", filterRule.Class, "err", err)
+				continue
+			}
+
+			filterRule.regex = r
+
+			filterRules = append(filterRules, filterRule)
+		}
+	}
+
+	return filterRules
+}
+
+// NewFilterStage creates the stage dropping unwanted events
+func NewFilterStage(config *Config) *FilterStage {
+	return &FilterStage{FilterRules: config.FilterRules}
+}
+
+// Process drops events not passing the filter rules
+func (stage *FilterStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if !getFilteredEvent(stage.FilterRules, logEvent, logger) {
+		logger.Debug("Event dropped by filter")
+		return nil, nil
+	}
+
+	return []LogEvent{logEvent}, nil
+}
+
+// Function to decide whether an event is kept, exclude rules win over include rules
+func getFilteredEvent(filterRules []FilterRule, logEvent LogEvent, logger Logger) bool {
+
+	hasIncludes := false
+	included := false
+
+	for _, filterRule := range filterRules {
+
+		// Only apply rules of the event's sourcetype
+		if filterRule.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		if filterRule.Action == "exclude" {
+			if filterRule.matches(logEvent) {
+				metrics.Inc("events_dropped_total", "sourcetype", logEvent.Sourcetype, "class", filterRule.Class)
+				return false
+			}
+			continue
+		}
+
+		// With include rules, events have to match at least one of them
+		hasIncludes = true
+		if !included {
+			included = filterRule.matches(logEvent)
+		}
+	}
+
+	if hasIncludes && !included {
+		metrics.Inc("events_dropped_total", "sourcetype", logEvent.Sourcetype, "class", "")
+		return false
+	}
+
+	return true
+}
+
+// Function to match a rule against the raw event or an extracted field
+func (filterRule FilterRule) matches(logEvent LogEvent) bool {
+
+	if filterRule.Field == "" {
+		return filterRule.regex.MatchString(logEvent.Event)
+	}
+
+	key, ok := getEventFieldKey(logEvent, filterRule.Field)
+	if !ok {
+		return false
+	}
+
+	if value, ok := logEvent.Fields[key]; ok {
+		return filterRule.regex.MatchString(value)
+	}
+
+	for _, value := range logEvent.MultiFields[key] {
+		if filterRule.regex.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
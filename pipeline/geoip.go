@@ -0,0 +1,220 @@
+package pipeline
+
+import (
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPField Struct
+type GeoIPField struct {
+	Sourcetype string `json:"sourcetype"`
+	Class      string `json:"class"`
+	Field      string `json:"field"`
+	Prefix     string `json:"prefix"`
+}
+
+// NetworkZone Struct
+type NetworkZone struct {
+	CIDR    string `json:"cidr"`
+	Zone    string `json:"zone"`
+	network *net.IPNet
+}
+
+// Record layout of MaxMind City databases
+type geoIPCityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// Record layout of MaxMind ASN databases
+type geoIPASNRecord struct {
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoIPStage Struct
+type GeoIPStage struct {
+	Fields       []GeoIPField
+	NetworkZones []NetworkZone
+	CityDB       *maxminddb.Reader
+	ASNDB        *maxminddb.Reader
+}
+
+// Function to open the local MaxMind databases configured in /conf/geoip/0
+func getGeoIPDatabases(source ConfigSource, logger Logger) (*maxminddb.Reader, *maxminddb.Reader) {
+
+	var cityDB, asnDB *maxminddb.Reader
+
+	item, GetItemerr := source.GetItem("/conf/geoip/0")
+	if GetItemerr != nil {
+		logger.DebugWith("No GeoIP databases configured", "err", GetItemerr)
+		return nil, nil
+	}
+
+	if path, ok := item["citydb"].(string); ok && path != "" {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			logger.ErrorWith("GeoIP City database error", "path", path, "err", err)
+		}
+		cityDB = reader
+	}
+
+	if path, ok := item["asndb"].(string); ok && path != "" {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			logger.ErrorWith("GeoIP ASN database error", "path", path, "err", err)
+		}
+		asnDB = reader
+	}
+
+	return cityDB, asnDB
+}
+
+// Function to fetch the CIDR to zone table from /conf/networks/
+func getNetworkZones(source ConfigSource, logger Logger) []NetworkZone {
+
+	var networkZones = make([]NetworkZone, 0)
+
+	items := getConfigItems(source, "conf/networks/", logger)
+
+	for item := range items {
+
+		networkZone := NetworkZone{}
+
+		networkZone.CIDR, _ = items[item]["cidr"].(string)
+		networkZone.Zone, _ = items[item]["zone"].(string)
+
+		_, network, err := net.ParseCIDR(networkZone.CIDR)
+		if err != nil || networkZone.Zone == "" {
+			logger.ErrorWith("Network zone invalid", "cidr", networkZone.CIDR, "zone", networkZone.Zone, "err", err)
+			continue
+		}
+
+		networkZone.network = network
+
+		networkZones = append(networkZones, networkZone)
+	}
+
+	// Most specific networks first, so the longest prefix wins
+	sort.SliceStable(networkZones, func(i, j int) bool {
+		onesI, _ := networkZones[i].network.Mask.Size()
+		onesJ, _ := networkZones[j].network.Mask.Size()
+		return onesI > onesJ
+	})
+
+	return networkZones
+}
+
+// Function to fetch IP typed fields from /conf/props/<sourcetype>/geoip/
+func getGeoIPFields(source ConfigSource, logger Logger) []GeoIPField {
+
+	var geoIPFields = make([]GeoIPField, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/geoip/", logger)
+
+		for item := range items {
+
+			geoIPField := GeoIPField{Sourcetype: sourcetype}
+
+			geoIPField.Class, _ = items[item]["class"].(string)
+			geoIPField.Field, _ = items[item]["field"].(string)
+			geoIPField.Prefix, _ = items[item]["prefix"].(string)
+
+			if geoIPField.Field == "" {
+				logger.ErrorWith("GeoIP field incomplete", "sourcetype", sourcetype, "class", geoIPField.Class)
+				continue
+			}
+
+			// Output fields are named after the IP field by default, e.g. src_country
+			if geoIPField.Prefix == "" {
+				geoIPField.Prefix = geoIPField.Field
+			}
+
+			geoIPFields = append(geoIPFields, geoIPField)
+		}
+	}
+
+	return geoIPFields
+}
+
+// NewGeoIPStage creates the stage enriching IP fields
+func NewGeoIPStage(config *Config) *GeoIPStage {
+	return &GeoIPStage{
+		Fields:       config.GeoIPFields,
+		NetworkZones: config.NetworkZones,
+		CityDB:       config.GeoIPCityDB,
+		ASNDB:        config.GeoIPASNDB,
+	}
+}
+
+// Process adds location, ASN and network zone fields for IP fields
+func (stage *GeoIPStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	for _, geoIPField := range stage.Fields {
+
+		// Only apply IP fields of the event's sourcetype
+		if geoIPField.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		value, ok := getEventField(logEvent, geoIPField.Field)
+		if !ok {
+			continue
+		}
+
+		ip := net.ParseIP(value)
+		if ip == nil {
+			continue
+		}
+
+		fields := make(map[string]string)
+
+		for _, networkZone := range stage.NetworkZones {
+			if networkZone.network.Contains(ip) {
+				fields[geoIPField.Prefix+"_zone"] = networkZone.Zone
+				break
+			}
+		}
+
+		if stage.CityDB != nil {
+			var record geoIPCityRecord
+			if err := stage.CityDB.Lookup(ip, &record); err != nil {
+				logger.DebugWith("GeoIP City lookup error", "ip", value, "err", err)
+			} else if record.Country.IsoCode != "" {
+				fields[geoIPField.Prefix+"_country"] = record.Country.IsoCode
+				fields[geoIPField.Prefix+"_lat"] = strconv.FormatFloat(record.Location.Latitude, 'f', -1, 64)
+				fields[geoIPField.Prefix+"_lon"] = strconv.FormatFloat(record.Location.Longitude, 'f', -1, 64)
+				if city := record.City.Names["en"]; city != "" {
+					fields[geoIPField.Prefix+"_city"] = city
+				}
+			}
+		}
+
+		if stage.ASNDB != nil {
+			var record geoIPASNRecord
+			if err := stage.ASNDB.Lookup(ip, &record); err != nil {
+				logger.DebugWith("GeoIP ASN lookup error", "ip", value, "err", err)
+			} else if record.AutonomousSystemNumber != 0 {
+				fields[geoIPField.Prefix+"_asn"] = strconv.FormatUint(uint64(record.AutonomousSystemNumber), 10)
+				fields[geoIPField.Prefix+"_as_org"] = record.AutonomousSystemOrganization
+			}
+		}
+
+		logEvent = addEventFields(logEvent, fields)
+	}
+
+	return []LogEvent{logEvent}, nil
+}
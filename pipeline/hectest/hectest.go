@@ -0,0 +1,133 @@
+// Package hectest provides a fake Splunk HTTP Event Collector, recording the events posted by the hec output
+package hectest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
+)
+
+// Server Struct, a running fake HEC endpoint
+type Server struct {
+	*httptest.Server
+
+	// Token expected in the Authorization header, any is accepted if empty
+	Token string
+
+	events []pipeline.HECEvent
+	status int
+
+	lock sync.Mutex
+}
+
+// NewServer starts a fake HEC endpoint expecting "Authorization: Splunk <token>"
+func NewServer(token string) *Server {
+
+	server := &Server{Token: token, status: http.StatusOK}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+
+	return server
+}
+
+// CollectorURL returns the URL of the collector endpoint
+func (server *Server) CollectorURL() string {
+	return server.URL + "/services/collector"
+}
+
+// Authorization returns the Authorization header value the server expects
+func (server *Server) Authorization() string {
+	return "Splunk " + server.Token
+}
+
+// Connection returns the attributes of a /conf/outputs/hec/0 item pointing to the server
+func (server *Server) Connection() map[string]interface{} {
+	return map[string]interface{}{"url": server.CollectorURL(), "authorization": server.Authorization()}
+}
+
+// Events returns the events received so far
+func (server *Server) Events() []pipeline.HECEvent {
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	return append([]pipeline.HECEvent(nil), server.events...)
+}
+
+// Reset forgets the events received so far
+func (server *Server) Reset() {
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.events = nil
+}
+
+// SetStatus makes the server answer with status, e.g. 503 to test output errors
+func (server *Server) SetStatus(status int) {
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.status = status
+}
+
+// Function to handle a post, HEC accepts several events concatenated in one body
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+
+	if server.Token != "" && r.Header.Get("Authorization") != server.Authorization() {
+		writeResponse(w, http.StatusUnauthorized, "Invalid authorization", 3)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, "Invalid data format", 6)
+		return
+	}
+
+	var events []pipeline.HECEvent
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var event pipeline.HECEvent
+		if err := decoder.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			writeResponse(w, http.StatusBadRequest, "Invalid data format", 6)
+			return
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		writeResponse(w, http.StatusBadRequest, "No data", 5)
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if server.status >= 300 {
+		writeResponse(w, server.status, http.StatusText(server.status), 9)
+		return
+	}
+
+	server.events = append(server.events, events...)
+
+	writeResponse(w, server.status, "Success", 0)
+}
+
+// Function to write a HEC style response
+func writeResponse(w http.ResponseWriter, status int, text string, code int) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"text": text, "code": code})
+}
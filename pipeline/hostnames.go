@@ -0,0 +1,273 @@
+package pipeline
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostConfig Struct
+type HostConfig struct {
+	ResolvePeer  bool     `json:"resolvepeer"`
+	ResolveHost  bool     `json:"resolvehost"`
+	Fields       []string `json:"fields"`
+	Lowercase    bool     `json:"lowercase"`
+	StripDomains []string `json:"stripdomains"`
+	StripAll     bool     `json:"stripall"`
+}
+
+// HostResolver resolves IP addresses to hostnames in the background with positive and negative caching
+type HostResolver struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	Timeout     time.Duration
+
+	lock    sync.Mutex
+	cache   map[string]hostCacheEntry
+	pending map[string]bool
+	limiter *tokenBucket
+}
+
+type hostCacheEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// tokenBucket allows rate events per second with bursts of up to burst events
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+// HostStage Struct
+type HostStage struct {
+	HostConfig   HostConfig
+	HostResolver *HostResolver
+}
+
+// Maximum number of cached hostnames, the cache is flushed when exceeded
+const hostCacheSize = 100000
+
+// Function to fetch hostname resolution and normalization settings from /conf/hosts/0
+func getHostConfig(source ConfigSource, logger Logger) (HostConfig, *HostResolver) {
+
+	var hostConfig HostConfig
+
+	item, GetItemerr := source.GetItem("/conf/hosts/0")
+	if GetItemerr != nil {
+		logger.DebugWith("No host normalization configured", "err", GetItemerr)
+		return hostConfig, nil
+	}
+
+	hostConfig.ResolvePeer = getConfigBool(item["resolvepeer"])
+	hostConfig.ResolveHost = getConfigBool(item["resolvehost"])
+	hostConfig.Lowercase = getConfigBool(item["lowercase"])
+	hostConfig.StripAll = getConfigBool(item["stripall"])
+
+	if fields, ok := item["fields"].(string); ok {
+		hostConfig.Fields = splitFieldList(fields)
+	}
+
+	if stripDomains, ok := item["stripdomains"].(string); ok {
+		for _, domain := range splitFieldList(stripDomains) {
+			hostConfig.StripDomains = append(hostConfig.StripDomains, "."+strings.Trim(strings.ToLower(domain), "."))
+		}
+	}
+
+	// Defaults: cache hits for an hour, misses for 5 minutes, at most 50 lookups per second
+	ttl, negativeTTL, rate := 3600, 300, 50
+
+	if value, ok := getConfigInt(item["ttl"]); ok {
+		ttl = value
+	}
+	if value, ok := getConfigInt(item["negativettl"]); ok {
+		negativeTTL = value
+	}
+	if value, ok := getConfigInt(item["rate"]); ok {
+		rate = value
+	}
+
+	hostResolver := NewHostResolver(time.Duration(ttl)*time.Second, time.Duration(negativeTTL)*time.Second, float64(rate))
+
+	return hostConfig, hostResolver
+}
+
+// Function to read boolean config attributes, stored as bool or string
+func getConfigBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// NewHostResolver creates a resolver doing at most rate lookups per second
+func NewHostResolver(ttl time.Duration, negativeTTL time.Duration, rate float64) *HostResolver {
+	return &HostResolver{
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+		Timeout:     2 * time.Second,
+		cache:       map[string]hostCacheEntry{},
+		pending:     map[string]bool{},
+		limiter:     newTokenBucket(rate, rate),
+	}
+}
+
+// Resolve returns the cached hostname of ip, or an empty string if it can't be resolved or was not resolved yet.
+// Events never wait for DNS, unknown and expired addresses are looked up in the background for the following events.
+func (resolver *HostResolver) Resolve(ip string) string {
+
+	resolver.lock.Lock()
+	defer resolver.lock.Unlock()
+
+	entry, ok := resolver.cache[ip]
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.hostname
+	}
+
+	// Expired hostnames are used until the lookup finished, lookups over the rate limit are retried by later events
+	if !resolver.pending[ip] && resolver.limiter.Allow() {
+		resolver.pending[ip] = true
+		go resolver.lookup(ip)
+	}
+
+	return entry.hostname
+}
+
+// Function to look up the hostname of ip and cache it, failed lookups are cached as empty hostname
+func (resolver *HostResolver) lookup(ip string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolver.Timeout)
+	defer cancel()
+
+	var hostname string
+
+	expires := time.Now().Add(resolver.NegativeTTL)
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err == nil && len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+		expires = time.Now().Add(resolver.TTL)
+	}
+
+	resolver.lock.Lock()
+	defer resolver.lock.Unlock()
+
+	if len(resolver.cache) >= hostCacheSize {
+		resolver.cache = map[string]hostCacheEntry{}
+	}
+
+	resolver.cache[ip] = hostCacheEntry{hostname, expires}
+	delete(resolver.pending, ip)
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Allow takes a token from the bucket if one is available
+func (bucket *tokenBucket) Allow() bool {
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+
+	now := time.Now()
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+// Function to normalize a hostname according to the lowercase and domain stripping rules
+func normalizeHostname(hostConfig HostConfig, hostname string) string {
+
+	hostname = strings.TrimSuffix(hostname, ".")
+
+	if hostConfig.Lowercase {
+		hostname = strings.ToLower(hostname)
+	}
+
+	// IP addresses are never stripped
+	if net.ParseIP(hostname) != nil {
+		return hostname
+	}
+
+	if hostConfig.StripAll {
+		return strings.SplitN(hostname, ".", 2)[0]
+	}
+
+	for _, domain := range hostConfig.StripDomains {
+		if strings.HasSuffix(strings.ToLower(hostname), domain) {
+			return hostname[:len(hostname)-len(domain)]
+		}
+	}
+
+	return hostname
+}
+
+// NewHostStage creates the stage resolving and normalizing hostnames
+func NewHostStage(config *Config) *HostStage {
+	return &HostStage{HostConfig: config.HostConfig, HostResolver: config.HostResolver}
+}
+
+// Process resolves and normalizes the event's host and IP valued host fields
+func (stage *HostStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	hostConfig := stage.HostConfig
+	hostResolver := stage.HostResolver
+
+	// Events without envelope host are attributed to the sender
+	if logEvent.Host == "" {
+		logEvent.Host = logEvent.Peer
+	}
+
+	if hostResolver != nil {
+		if hostConfig.ResolveHost && net.ParseIP(logEvent.Host) != nil {
+			if hostname := hostResolver.Resolve(logEvent.Host); hostname != "" {
+				logEvent.Host = hostname
+			}
+		}
+
+		if hostConfig.ResolvePeer && logEvent.Peer != "" {
+			if hostname := hostResolver.Resolve(logEvent.Peer); hostname != "" {
+				logEvent = addEventFields(logEvent, map[string]string{"peer_host": normalizeHostname(hostConfig, hostname)})
+			}
+		}
+
+		for _, field := range hostConfig.Fields {
+
+			value, ok := getEventField(logEvent, field)
+			if !ok {
+				continue
+			}
+
+			if net.ParseIP(value) == nil {
+				continue
+			}
+
+			if hostname := hostResolver.Resolve(value); hostname != "" {
+				logEvent = addEventFields(logEvent, map[string]string{field + "_host": normalizeHostname(hostConfig, hostname)})
+			}
+		}
+	}
+
+	logEvent.Host = normalizeHostname(hostConfig, logEvent.Host)
+
+	return []LogEvent{logEvent}, nil
+}
@@ -0,0 +1,222 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"time"
+)
+
+// TCPInput Struct, reads newline separated events from TCP connections
+type TCPInput struct {
+	Address  string
+	Timeout  time.Duration
+	Pipeline *Pipeline
+	Logger   Logger
+
+	// Address /metrics is served on
+	MetricsAddress string
+
+	// Lines not matching the line breaker are appended to the previous line, for multiline events
+	LineBreaker *regexp.Regexp
+}
+
+// NewTCPInput creates an input for the input config, TCPINPUT_BINDADDR and TCPINPUT_PORT override the configured address,
+// METRICS_ADDRESS the metrics address
+func NewTCPInput(inputConfig InputConfig, pipeline *Pipeline, logger Logger) (*TCPInput, error) {
+
+	input := &TCPInput{
+		Address:        inputConfig.Address,
+		Timeout:        30 * time.Second,
+		Pipeline:       pipeline,
+		Logger:         logger,
+		MetricsAddress: inputConfig.MetricsAddress,
+	}
+
+	// Define default address
+	if input.Address == "" {
+		input.Address = "0.0.0.0:12000"
+	}
+
+	if value := os.Getenv("METRICS_ADDRESS"); value != "" {
+		input.MetricsAddress = value
+	}
+
+	if input.MetricsAddress == "" {
+		input.MetricsAddress = "0.0.0.0:9102"
+	}
+
+	bindAddr, port, err := net.SplitHostPort(input.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make Bindadress configurable
+	if value := os.Getenv("TCPINPUT_BINDADDR"); value != "" {
+		bindAddr = value
+	}
+
+	// Make Port configurable
+	if value := os.Getenv("TCPINPUT_PORT"); value != "" {
+		port = value
+	}
+
+	input.Address = net.JoinHostPort(bindAddr, port)
+
+	if inputConfig.Timeout != "" {
+		if input.Timeout, err = time.ParseDuration(inputConfig.Timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	if inputConfig.LineBreaker != "" {
+		if input.LineBreaker, err = regexp.Compile(inputConfig.LineBreaker); err != nil {
+			return nil, err
+		}
+	}
+
+	return input, nil
+}
+
+// NewTCPInputFromConfig builds the pipeline of a tcpinput daemon from PIPELINE_CONFIG, or defaultConfig if unset.
+// Stages needing the configuration below /conf/ read it from CONFIG_SOURCE, daemons have no v3io container.
+func NewTCPInputFromConfig(defaultConfig string, logger Logger) (*TCPInput, error) {
+
+	pipelineConfig, err := LoadPipelineConfig(os.Getenv("PIPELINE_CONFIG"), nil, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if pipelineConfig.Input.Type != "tcp" {
+		return nil, fmt.Errorf("pipeline %s: input.type has to be tcp for tcpinput daemons", pipelineConfig.Name)
+	}
+
+	var config *Config
+
+	if pipelineConfig.NeedsConfig() {
+		source, err := OpenConfigSource(os.Getenv("CONFIG_SOURCE"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %v", pipelineConfig.Name, err)
+		}
+		if config, err = LoadConfigFromSource(source, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	pipeline, err := pipelineConfig.Build(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTCPInput(pipelineConfig.Input, pipeline, logger)
+}
+
+// ListenAndServe accepts connections until the listener fails, metrics are served alongside
+func (input *TCPInput) ListenAndServe() error {
+
+	ServeMetrics(input.MetricsAddress, input.Logger)
+
+	// Create listener
+	listener, err := net.Listen("tcp", input.Address)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		listener.Close()
+		input.Logger.Info("Listener closed")
+	}()
+
+	for {
+		// Get net.TCPConn object
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		// Run the connection handler
+		go input.handleConnection(conn)
+	}
+}
+
+// Function to read the events of one connection, every event is passed through the pipeline
+func (input *TCPInput) handleConnection(conn net.Conn) {
+
+	input.Logger.InfoWith("Handling new connection", "remote", conn.RemoteAddr().String())
+
+	metrics.Inc("tcpinput_connections_total", "pipeline", input.Pipeline.Name)
+	metrics.AddGauge("tcpinput_connections", 1, "pipeline", input.Pipeline.Name)
+
+	// Close connection when this function ends
+	defer func() {
+		input.Logger.Info("Closing connection")
+		conn.Close()
+		metrics.AddGauge("tcpinput_connections", -1, "pipeline", input.Pipeline.Name)
+	}()
+
+	// Record the sender's address, the envelope host is whatever the sender put there
+	peer, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	// Create linescanner
+	scanner := bufio.NewScanner(bufio.NewReader(conn))
+
+	var event string
+
+	conn.SetReadDeadline(time.Now().Add(input.Timeout))
+
+	// Loop over Lines
+	for scanner.Scan() {
+
+		line := scanner.Text()
+
+		metrics.Add("tcpinput_bytes_total", float64(len(line)+1), "pipeline", input.Pipeline.Name)
+
+		if input.LineBreaker != nil && !input.LineBreaker.MatchString(line) && event != "" {
+			// Append next line to event
+			event = event + "\n" + line
+		} else {
+			input.process(event, peer)
+			event = line
+		}
+
+		// Without line breaker every line is an event
+		if input.LineBreaker == nil {
+			input.process(event, peer)
+			event = ""
+		}
+
+		// Reset timeout before looping
+		conn.SetReadDeadline(time.Now().Add(input.Timeout))
+	}
+
+	// Error handling
+	if err := scanner.Err(); err != nil {
+		input.Logger.ErrorWith("Connection read error", "remote", conn.RemoteAddr().String(), "err", err)
+		metrics.Inc("tcpinput_read_errors_total", "pipeline", input.Pipeline.Name)
+	}
+
+	// After the timeout, we should also persist
+	input.process(event, peer)
+}
+
+// Function to pass a single event through the pipeline, errors are logged and the event is lost
+func (input *TCPInput) process(event string, peer string) {
+
+	if event == "" {
+		return
+	}
+
+	logEvent := NewLogEvent()
+	logEvent.Event = event
+	logEvent.Peer = peer
+
+	// Events are not spooled to disk, the depth is the number of events read but not yet passed on by the pipeline
+	metrics.AddGauge("tcpinput_spool_depth", 1, "pipeline", input.Pipeline.Name)
+	defer metrics.AddGauge("tcpinput_spool_depth", -1, "pipeline", input.Pipeline.Name)
+
+	if _, err := input.Pipeline.Process(logEvent, input.Logger); err != nil {
+		input.Logger.ErrorWith("Pipeline error", "peer", peer, "err", err)
+	}
+}
@@ -0,0 +1,221 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// FieldPrefix is added to extracted field names if PrefixFields is set
+const FieldPrefix = "nuclio."
+
+// LogEventContentType is the content type of LogEvent JSON in typed stream records
+const LogEventContentType = "application/vnd.nuclio-event-etl.logevent+json; version=1"
+
+// LogEvent Struct
+type LogEvent struct {
+	Time       string            `json:"time"`
+	Meta       string            `json:"meta"`
+	Host       string            `json:"host"`
+	Sourcetype string            `json:"sourcetype"`
+	Source     string            `json:"source"`
+	Index      string            `json:"index"`
+	Event      string            `json:"event"`
+	Peer       string            `json:"peer"`
+	Fields     map[string]string `json:"fields"`
+
+	// Fields with more than one value, only set by multi-match extractions
+	MultiFields map[string][]string `json:"-"`
+
+	// Prefix extracted fields with FieldPrefix, set from the Field-Prefix-Mode header
+	PrefixFields bool `json:"-"`
+
+	// Event rewrite done by the format stage (normal, minimal, kv, none)
+	OutputMode string `json:"-"`
+
+	// Set once redaction ran, unmasked events are never sent
+	masked bool
+}
+
+// HECEvent Struct
+type HECEvent struct {
+	Time       string                 `json:"time"`
+	Host       string                 `json:"host"`
+	Sourcetype string                 `json:"sourcetype"`
+	Source     string                 `json:"source"`
+	Index      string                 `json:"index"`
+	Event      string                 `json:"event"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// TypedRecord Struct, stream record data naming its content type, so readers tell LogEvents from raw events
+type TypedRecord struct {
+	ContentType string          `json:"contenttype"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// NewLogEvent creates an empty LogEvent with its field maps set up
+func NewLogEvent() LogEvent {
+	return LogEvent{Fields: map[string]string{}, MultiFields: map[string][]string{}}
+}
+
+// ParseLogEvent unmarshals a JSON encoded LogEvent, fields sent along are discarded
+func ParseLogEvent(body []byte) (LogEvent, error) {
+
+	var logEvent LogEvent
+
+	err := json.Unmarshal(body, &logEvent)
+
+	// Setting up field key/value maps
+	logEvent.Fields = map[string]string{}
+	logEvent.MultiFields = map[string][]string{}
+
+	return logEvent, err
+}
+
+// ParseStreamRecord reads the data of a stream record, a typed record holding a LogEvent or the raw event text
+func ParseStreamRecord(data []byte) LogEvent {
+
+	var typedRecord TypedRecord
+
+	if json.Unmarshal(data, &typedRecord) == nil && typedRecord.ContentType == LogEventContentType {
+		if logEvent, err := ParseLogEvent(typedRecord.Data); err == nil {
+			return logEvent
+		}
+	}
+
+	logEvent := NewLogEvent()
+	logEvent.Event = string(data)
+
+	return logEvent
+}
+
+// DoRegexMatch returns the named groups of the first match, nil if the regex does not match
+func DoRegexMatch(r *regexp.Regexp, str string) map[string]string {
+
+	match := r.FindStringSubmatch(str)
+
+	if match != nil {
+		subMatchMap := make(map[string]string)
+		for i, name := range r.SubexpNames() {
+			if i != 0 {
+				subMatchMap[name] = match[i]
+			}
+		}
+		return subMatchMap
+
+	}
+	return nil
+}
+
+// DoRegexMatchAll collects the values of all matches, for patterns repeating within an event
+func DoRegexMatchAll(r *regexp.Regexp, str string) map[string][]string {
+
+	matches := r.FindAllStringSubmatch(str, -1)
+
+	if matches != nil {
+		subMatchMap := make(map[string][]string)
+		for _, match := range matches {
+			for i, name := range r.SubexpNames() {
+				// Skip unnamed groups and groups not taking part in this match
+				if i != 0 && name != "" && match[i] != "" {
+					subMatchMap[name] = append(subMatchMap[name], match[i])
+				}
+			}
+		}
+		return subMatchMap
+
+	}
+	return nil
+}
+
+// Function to add extracted fields to the event, prefixing them if requested
+func addEventFields(logEvent LogEvent, fields map[string]string) LogEvent {
+
+	for key, value := range fields {
+		if logEvent.PrefixFields {
+			key = FieldPrefix + key
+		}
+
+		logEvent.Fields[key] = value
+	}
+
+	return logEvent
+}
+
+// Function to add multi valued fields to the event, prefixing them if requested
+func addEventMultiFields(logEvent LogEvent, fields map[string][]string) LogEvent {
+
+	for key, values := range fields {
+		if logEvent.PrefixFields {
+			key = FieldPrefix + key
+		}
+
+		// Single values are kept as normal fields
+		if len(values) == 1 {
+			logEvent.Fields[key] = values[0]
+			delete(logEvent.MultiFields, key)
+			continue
+		}
+
+		logEvent.MultiFields[key] = values
+		delete(logEvent.Fields, key)
+	}
+
+	return logEvent
+}
+
+// Function to find the key of a field, named as given or with the prefix added by Field-Prefix-Mode
+func getEventFieldKey(logEvent LogEvent, name string) (string, bool) {
+
+	for _, key := range []string{name, FieldPrefix + name} {
+		if _, ok := logEvent.Fields[key]; ok {
+			return key, true
+		}
+		if _, ok := logEvent.MultiFields[key]; ok {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// Function to get the value of a single valued field, named as given or with the nuclio prefix
+func getEventField(logEvent LogEvent, name string) (string, bool) {
+
+	if value, ok := logEvent.Fields[name]; ok {
+		return value, true
+	}
+
+	value, ok := logEvent.Fields[FieldPrefix+name]
+
+	return value, ok
+}
+
+// GetHECEvent converts a LogEvent into a HEC conform event
+func GetHECEvent(logEvent LogEvent) HECEvent {
+
+	hecEvent := HECEvent{
+		// Adding subsecond resolution to time element
+		Time:       logEvent.Time + logEvent.Fields["_subsecond"],
+		Host:       logEvent.Host,
+		Sourcetype: logEvent.Sourcetype,
+		Source:     logEvent.Source,
+		Index:      logEvent.Index,
+		Event:      logEvent.Event,
+		Fields:     map[string]interface{}{},
+	}
+
+	for key, value := range logEvent.Fields {
+		hecEvent.Fields[key] = value
+	}
+
+	// Multi valued fields are sent as arrays
+	for key, values := range logEvent.MultiFields {
+		hecEvent.Fields[key] = values
+	}
+
+	// Internal fields are not sent to HEC
+	delete(hecEvent.Fields, "_subsecond")
+
+	return hecEvent
+}
@@ -0,0 +1,386 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lookup Struct
+type Lookup struct {
+	Name string        `json:"name"`
+	Type string        `json:"type"`
+	Path string        `json:"path"`
+	Key  string        `json:"key"`
+	TTL  time.Duration `json:"ttl"`
+
+	source ConfigSource
+	lock   sync.Mutex
+
+	// Rows of csv lookups, keyed by the key column, replaced by one worker while the others keep using them
+	rows       map[string]map[string]string
+	loadedAt   time.Time
+	refreshing bool
+
+	// Cached rows of kv lookups, misses and errors are cached as nil rows
+	cache map[string]lookupCacheEntry
+}
+
+type lookupCacheEntry struct {
+	row     map[string]string
+	expires time.Time
+}
+
+// LookupOutput Struct
+type LookupOutput struct {
+	Column string `json:"column"`
+	Field  string `json:"field"`
+}
+
+// LookupField Struct
+type LookupField struct {
+	Sourcetype string         `json:"sourcetype"`
+	Class      string         `json:"class"`
+	Lookup     string         `json:"lookup"`
+	Field      string         `json:"field"`
+	Outputs    []LookupOutput `json:"outputs"`
+}
+
+// LookupStage Struct
+type LookupStage struct {
+	Lookups      map[string]*Lookup
+	LookupFields []LookupField
+}
+
+// Maximum number of cached kv lookup rows, the cache is flushed when exceeded
+const lookupCacheSize = 100000
+
+// Time failed kv fetches are cached, v3io doesn't tell missing keys from other errors, so misses are only cached as long
+const lookupErrorTTL = 10 * time.Second
+
+// Function to fetch lookup definitions from /conf/lookups/
+func getLookups(source ConfigSource, logger Logger) map[string]*Lookup {
+
+	var lookups = make(map[string]*Lookup)
+
+	items := getConfigItems(source, "conf/lookups/", logger)
+
+	for item := range items {
+
+		lookup := &Lookup{
+			Type:   "kv",
+			TTL:    300 * time.Second,
+			source: source,
+			cache:  map[string]lookupCacheEntry{},
+		}
+
+		lookup.Name, _ = items[item]["name"].(string)
+		lookup.Path, _ = items[item]["path"].(string)
+		lookup.Key, _ = items[item]["key"].(string)
+
+		if lookupType, ok := items[item]["type"].(string); ok && lookupType != "" {
+			lookup.Type = lookupType
+		}
+
+		if ttl, ok := getConfigInt(items[item]["ttl"]); ok {
+			lookup.TTL = time.Duration(ttl) * time.Second
+		}
+
+		if lookup.Name == "" || lookup.Path == "" || (lookup.Type != "kv" && lookup.Type != "csv") {
+			logger.ErrorWith("Lookup definition invalid", "name", lookup.Name, "type", lookup.Type, "path", lookup.Path)
+			continue
+		}
+
+		// Csv lookups are loaded completely, failing early on missing files
+		if lookup.Type == "csv" {
+			rows, key, err := lookup.loadCSV()
+			if err != nil {
+				logger.ErrorWith("Lookup load error", "name", lookup.Name, "err", err)
+				continue
+			}
+			lookup.rows, lookup.Key, lookup.loadedAt = rows, key, time.Now()
+		}
+
+		lookups[lookup.Name] = lookup
+	}
+
+	return lookups
+}
+
+// Function to fetch lookup usages from /conf/props/<sourcetype>/lookup/
+func getLookupFields(source ConfigSource, lookups map[string]*Lookup, logger Logger) []LookupField {
+
+	var lookupFields = make([]LookupField, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/lookup/", logger)
+
+		for item := range items {
+
+			lookupField := LookupField{Sourcetype: sourcetype}
+
+			lookupField.Class, _ = items[item]["class"].(string)
+			lookupField.Lookup, _ = items[item]["lookup"].(string)
+			lookupField.Field, _ = items[item]["field"].(string)
+
+			if _, ok := lookups[lookupField.Lookup]; !ok || lookupField.Field == "" {
+				logger.ErrorWith("Lookup field invalid", "sourcetype", sourcetype, "class", lookupField.Class, "lookup", lookupField.Lookup)
+				continue
+			}
+
+			// Outputs are given like Splunk's OUTPUT clause: "owner, department AS user_department"
+			if outputs, ok := items[item]["outputs"].(string); ok {
+				lookupField.Outputs = parseLookupOutputs(outputs)
+			}
+
+			lookupFields = append(lookupFields, lookupField)
+		}
+	}
+
+	return lookupFields
+}
+
+var lookupOutputRegex = regexp.MustCompile(`(?i)^\s*(?P<column>\S+)(?:\s+as\s+(?P<field>\S+))?\s*$`)
+
+func parseLookupOutputs(outputs string) []LookupOutput {
+
+	var lookupOutputs []LookupOutput
+
+	for _, output := range strings.Split(outputs, ",") {
+
+		match := DoRegexMatch(lookupOutputRegex, output)
+		if match == nil {
+			continue
+		}
+
+		if match["field"] == "" {
+			match["field"] = match["column"]
+		}
+
+		lookupOutputs = append(lookupOutputs, LookupOutput{match["column"], match["field"]})
+	}
+
+	return lookupOutputs
+}
+
+// Function to read integer config attributes, v3io returns numbers as int or float
+func getConfigInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// Function to read decimal config attributes, v3io returns numbers as int or float
+func getConfigFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Get returns the row for key, or nil if the key is not in the lookup
+func (lookup *Lookup) Get(key string, logger Logger) map[string]string {
+
+	lookup.lock.Lock()
+
+	if lookup.Type == "csv" {
+		// Refresh expired tables by one worker, the others keep using the old rows
+		if time.Since(lookup.loadedAt) > lookup.TTL && !lookup.refreshing {
+			lookup.refreshing = true
+			lookup.lock.Unlock()
+
+			// Not locked while loading, so workers don't wait for the round trip
+			rows, _, err := lookup.loadCSV()
+
+			lookup.lock.Lock()
+
+			// The old rows are kept if loading fails, retrying after the TTL
+			if err != nil {
+				logger.WarnWith("Lookup refresh error", "name", lookup.Name, "err", err)
+			} else {
+				lookup.rows = rows
+			}
+			lookup.loadedAt = time.Now()
+			lookup.refreshing = false
+		}
+
+		row := lookup.rows[key]
+		lookup.lock.Unlock()
+
+		return row
+	}
+
+	if entry, ok := lookup.cache[key]; ok && time.Now().Before(entry.expires) {
+		lookup.lock.Unlock()
+		return entry.row
+	}
+
+	// Not locked while fetching, so workers don't wait for each other's round trips
+	lookup.lock.Unlock()
+
+	ttl := lookup.TTL
+
+	row, err := lookup.getKVRow(key)
+	if err != nil {
+		logger.DebugWith("Lookup miss", "name", lookup.Name, "key", key, "err", err)
+		ttl = lookupErrorTTL
+	}
+
+	lookup.lock.Lock()
+	defer lookup.lock.Unlock()
+
+	if len(lookup.cache) >= lookupCacheSize {
+		lookup.cache = map[string]lookupCacheEntry{}
+	}
+
+	lookup.cache[key] = lookupCacheEntry{row, time.Now().Add(ttl)}
+
+	return row
+}
+
+// Function to fetch a single row of a kv lookup, the key is the item name
+func (lookup *Lookup) getKVRow(key string) (map[string]string, error) {
+
+	// Keys can't address items outside of the table
+	if key == "" || strings.Contains(key, "/") {
+		return nil, fmt.Errorf("invalid key")
+	}
+
+	item, GetItemerr := lookup.source.GetItem(strings.TrimSuffix(lookup.Path, "/") + "/" + key)
+	if GetItemerr != nil {
+		return nil, GetItemerr
+	}
+
+	row := make(map[string]string)
+	for column, value := range item {
+		row[column] = fmt.Sprint(value)
+	}
+
+	return row, nil
+}
+
+// Function to load a csv lookup from a v3io object, the first line names the columns. Returns the rows and the key
+// column, the lookup itself is not changed.
+func (lookup *Lookup) loadCSV() (map[string]map[string]string, string, error) {
+
+	body, GetObjecterr := lookup.source.GetObject(lookup.Path)
+	if GetObjecterr != nil {
+		return nil, "", GetObjecterr
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(records) == 0 {
+		return nil, "", fmt.Errorf("lookup %s is empty", lookup.Name)
+	}
+
+	header := records[0]
+
+	// Key column defaults to the first column, it is set on the first load and the same on refreshes
+	key := lookup.Key
+	if key == "" {
+		key = header[0]
+	}
+
+	keyColumn := -1
+	for i, column := range header {
+		if column == key {
+			keyColumn = i
+		}
+	}
+
+	if keyColumn < 0 {
+		return nil, "", fmt.Errorf("lookup %s has no key column %s", lookup.Name, key)
+	}
+
+	rows := make(map[string]map[string]string)
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows[record[keyColumn]] = row
+	}
+
+	return rows, key, nil
+}
+
+// NewLookupStage creates the stage enriching events from lookup tables
+func NewLookupStage(config *Config) *LookupStage {
+	return &LookupStage{Lookups: config.Lookups, LookupFields: config.LookupFields}
+}
+
+// Process adds lookup output columns to field list
+func (stage *LookupStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	for _, lookupField := range stage.LookupFields {
+
+		// Only apply lookups of the event's sourcetype
+		if lookupField.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		value, ok := getEventField(logEvent, lookupField.Field)
+		if !ok {
+			continue
+		}
+
+		lookup := stage.Lookups[lookupField.Lookup]
+
+		row := lookup.Get(value, logger)
+		if row == nil {
+			continue
+		}
+
+		outputs := lookupField.Outputs
+
+		// Without outputs all columns but the key are added
+		if len(outputs) == 0 {
+			for column := range row {
+				// Skip key and v3io system attributes
+				if column != lookup.Key && !strings.HasPrefix(column, "__") {
+					outputs = append(outputs, LookupOutput{column, column})
+				}
+			}
+		}
+
+		fields := make(map[string]string)
+		for _, output := range outputs {
+			if columnValue, ok := row[output.Column]; ok && columnValue != "" {
+				fields[output.Field] = columnValue
+			}
+		}
+
+		logEvent = addEventFields(logEvent, fields)
+	}
+
+	return []LogEvent{logEvent}, nil
+}
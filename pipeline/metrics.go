@@ -0,0 +1,321 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Upper bounds in seconds of the histogram buckets, from regex matches to output requests
+var defaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// MetricRegistry holds counters, gauges and histograms by name and label values, the lock only guards adding series,
+// values change atomically and histograms have a lock of their own, so workers counting do not wait for each other
+type MetricRegistry struct {
+	lock       sync.RWMutex
+	counters   map[string]map[string]*metricValue
+	gauges     map[string]map[string]*metricValue
+	histograms map[string]map[string]*histogram
+}
+
+// Struct of a counter or gauge value, the bits of a float64 so it can be changed with atomic operations
+type metricValue struct {
+	bits uint64
+}
+
+// Struct of the observations of one histogram, counts are per bucket and not cumulative
+type histogram struct {
+	lock   sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var metrics = NewMetricRegistry()
+
+// NewMetricRegistry creates an empty registry
+func NewMetricRegistry() *MetricRegistry {
+	return &MetricRegistry{
+		counters:   map[string]map[string]*metricValue{},
+		gauges:     map[string]map[string]*metricValue{},
+		histograms: map[string]map[string]*histogram{},
+	}
+}
+
+// Metrics returns the registry the stages and inputs count into
+func Metrics() *MetricRegistry {
+	return metrics
+}
+
+// Add increases a counter, labels are given as name/value pairs
+func (registry *MetricRegistry) Add(name string, value float64, labels ...string) {
+	registry.series(registry.counters, name, labels).add(value)
+}
+
+// Inc increases a counter by one
+func (registry *MetricRegistry) Inc(name string, labels ...string) {
+	registry.Add(name, 1, labels...)
+}
+
+// Get returns the current value of a counter or gauge
+func (registry *MetricRegistry) Get(name string, labels ...string) float64 {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	values, ok := registry.gauges[name]
+	if !ok {
+		values = registry.counters[name]
+	}
+
+	if series := values[joinLabels(labels)]; series != nil {
+		return series.load()
+	}
+
+	return 0
+}
+
+// Set sets a gauge
+func (registry *MetricRegistry) Set(name string, value float64, labels ...string) {
+	registry.series(registry.gauges, name, labels).set(value)
+}
+
+// AddGauge changes a gauge by value, which may be negative
+func (registry *MetricRegistry) AddGauge(name string, value float64, labels ...string) {
+	registry.series(registry.gauges, name, labels).add(value)
+}
+
+// Observe adds a duration in seconds, or any other value, to a histogram
+func (registry *MetricRegistry) Observe(name string, value float64, labels ...string) {
+
+	key := joinLabels(labels)
+
+	registry.lock.RLock()
+	h := registry.histograms[name][key]
+	registry.lock.RUnlock()
+
+	if h == nil {
+		registry.lock.Lock()
+		if registry.histograms[name] == nil {
+			registry.histograms[name] = map[string]*histogram{}
+		}
+		if h = registry.histograms[name][key]; h == nil {
+			h = &histogram{counts: make([]uint64, len(defaultBuckets))}
+			registry.histograms[name][key] = h
+		}
+		registry.lock.Unlock()
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for i, bound := range defaultBuckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+
+	h.sum += value
+	h.count++
+}
+
+// Function to get the value of a counter or gauge series, the write lock is only taken to add new series
+func (registry *MetricRegistry) series(values map[string]map[string]*metricValue, name string, labels []string) *metricValue {
+
+	key := joinLabels(labels)
+
+	registry.lock.RLock()
+	series := values[name][key]
+	registry.lock.RUnlock()
+
+	if series != nil {
+		return series
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if values[name] == nil {
+		values[name] = map[string]*metricValue{}
+	}
+
+	if series = values[name][key]; series == nil {
+		series = &metricValue{}
+		values[name][key] = series
+	}
+
+	return series
+}
+
+// Function to add to a value, retried until no other worker changed it in between
+func (series *metricValue) add(delta float64) {
+	for {
+		bits := atomic.LoadUint64(&series.bits)
+		if atomic.CompareAndSwapUint64(&series.bits, bits, math.Float64bits(math.Float64frombits(bits)+delta)) {
+			return
+		}
+	}
+}
+
+// Function to set a value
+func (series *metricValue) set(value float64) {
+	atomic.StoreUint64(&series.bits, math.Float64bits(value))
+}
+
+// Function to read a value
+func (series *metricValue) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&series.bits))
+}
+
+// Write writes all metrics in the Prometheus text exposition format, sorted by name and labels
+func (registry *MetricRegistry) Write(w io.Writer) error {
+	registry.lock.RLock()
+
+	var buffer bytes.Buffer
+
+	for _, name := range sortedNames(registry.counters) {
+		fmt.Fprintf(&buffer, "# TYPE %s counter\n", name)
+		for _, key := range sortedKeys(registry.counters[name]) {
+			fmt.Fprintf(&buffer, "%s %s\n", withLabels(name, key), formatValue(registry.counters[name][key].load()))
+		}
+	}
+
+	for _, name := range sortedNames(registry.gauges) {
+		fmt.Fprintf(&buffer, "# TYPE %s gauge\n", name)
+		for _, key := range sortedKeys(registry.gauges[name]) {
+			fmt.Fprintf(&buffer, "%s %s\n", withLabels(name, key), formatValue(registry.gauges[name][key].load()))
+		}
+	}
+
+	var histogramNames []string
+	for name := range registry.histograms {
+		histogramNames = append(histogramNames, name)
+	}
+	sort.Strings(histogramNames)
+
+	for _, name := range histogramNames {
+		fmt.Fprintf(&buffer, "# TYPE %s histogram\n", name)
+
+		var keys []string
+		for key := range registry.histograms[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			h := registry.histograms[name][key]
+
+			// Buckets, sum and count are written consistently, from the same observations
+			h.lock.Lock()
+
+			var cumulative uint64
+			for i, bound := range defaultBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(&buffer, "%s %d\n", withLabels(name+"_bucket", joinKeys(key, `le="`+formatValue(bound)+`"`)), cumulative)
+			}
+			fmt.Fprintf(&buffer, "%s %d\n", withLabels(name+"_bucket", joinKeys(key, `le="+Inf"`)), h.count)
+			fmt.Fprintf(&buffer, "%s %s\n", withLabels(name+"_sum", key), formatValue(h.sum))
+			fmt.Fprintf(&buffer, "%s %d\n", withLabels(name+"_count", key), h.count)
+
+			h.lock.Unlock()
+		}
+	}
+
+	registry.lock.RUnlock()
+
+	_, err := w.Write(buffer.Bytes())
+
+	return err
+}
+
+// ServeHTTP serves the metrics to Prometheus
+func (registry *MetricRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	registry.Write(w)
+}
+
+// ServeMetrics serves the metrics on address at /metrics in the background, errors are logged
+func ServeMetrics(address string, logger Logger) {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			logger.ErrorWith("Metrics listener error", "address", address, "err", err)
+		}
+	}()
+}
+
+// Function to join label pairs into a stable key: name="value",...
+func joinLabels(labels []string) string {
+
+	var pairs []string
+
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.Replace(labels[i+1], `\`, `\\`, -1)
+		value = strings.Replace(value, "\n", `\n`, -1)
+		value = strings.Replace(value, "\"", "\\\"", -1)
+		pairs = append(pairs, labels[i]+"=\""+value+"\"")
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// Function to append a label to a joined key, the le label of histogram buckets comes last
+func joinKeys(key string, label string) string {
+
+	if key == "" {
+		return label
+	}
+
+	return key + "," + label
+}
+
+// Function to build a sample name with its labels, name{key}
+func withLabels(name string, key string) string {
+
+	if key == "" {
+		return name
+	}
+
+	return name + "{" + key + "}"
+}
+
+// Function to format a sample value in its shortest form, Prometheus accepts exponents
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Function to get the metric names of counters or gauges, sorted
+func sortedNames(values map[string]map[string]*metricValue) []string {
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Function to get the label keys of a metric, sorted
+func sortedKeys(values map[string]*metricValue) []string {
+
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMetricRegistryConcurrent(t *testing.T) {
+
+	registry := NewMetricRegistry()
+
+	var wait sync.WaitGroup
+
+	for worker := 0; worker < 8; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				registry.Inc("events_total", "sourcetype", "a")
+				registry.Add("bytes_total", 0.5)
+				registry.AddGauge("connections", 1)
+				registry.AddGauge("connections", -1)
+				registry.Observe("duration_seconds", 0.002, "stage", "extract")
+			}
+		}()
+	}
+
+	// Written while the workers count
+	var buffer bytes.Buffer
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	wait.Wait()
+
+	if got := registry.Get("events_total", "sourcetype", "a"); got != 8000 {
+		t.Errorf("events_total %v, want 8000", got)
+	}
+
+	if got := registry.Get("bytes_total"); got != 4000 {
+		t.Errorf("bytes_total %v, want 4000", got)
+	}
+
+	if got := registry.Get("connections"); got != 0 {
+		t.Errorf("connections %v, want 0", got)
+	}
+
+	if got := registry.Get("missing", "sourcetype", "a"); got != 0 {
+		t.Errorf("missing %v, want 0", got)
+	}
+
+	buffer.Reset()
+	if err := registry.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# TYPE events_total counter\nevents_total{sourcetype=\"a\"} 8000\n",
+		"# TYPE connections gauge\nconnections 0\n",
+		"duration_seconds_bucket{stage=\"extract\",le=\"0.001\"} 0\n",
+		"duration_seconds_bucket{stage=\"extract\",le=\"0.005\"} 8000\n",
+		"duration_seconds_count{stage=\"extract\"} 8000\n",
+	} {
+		if !strings.Contains(buffer.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, buffer.String())
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HECOutputStage Struct
+type HECOutputStage struct {
+	Connection HECConnection
+}
+
+// HTTPOutputStage Struct, posts the JSON encoded LogEvent to the next function
+type HTTPOutputStage struct {
+	URL string
+
+	// Events are masked by the next function
+	Unmasked bool
+}
+
+// StreamOutputStage Struct
+type StreamOutputStage struct {
+	URL        string
+	StreamName string
+
+	// Send only the event text instead of the JSON encoded LogEvent
+	Raw bool
+
+	// Wrap the LogEvent JSON in a TypedRecord, for readers of streams also holding raw events
+	Typed bool
+
+	// Events are masked by the function reading the stream
+	Unmasked bool
+}
+
+// FileOutputStage Struct
+type FileOutputStage struct {
+	File *os.File
+
+	// Events are written as received
+	Unmasked bool
+
+	lock sync.Mutex
+}
+
+// StreamRecord Struct
+type StreamRecord struct {
+	StreamName string   `json:"StreamName"`
+	Records    []Record `json:"Records"`
+}
+
+// Record Struct
+type Record struct {
+	ClientInfo   string `json:"ClientInfo"`
+	Data         string `json:"Data"`
+	PartitionKey string `json:"PartitionKey"`
+	ShardID      int    `json:"ShardId"`
+}
+
+// NewHECOutputStage creates the stage sending events to the HTTP Event Collector
+func NewHECOutputStage(config *Config) *HECOutputStage {
+	return &HECOutputStage{Connection: config.HECConnection}
+}
+
+// Process sends the event to HEC, events which skipped masking are never sent
+func (stage *HECOutputStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if err := checkMasked(logEvent, false, logger); err != nil {
+		return nil, err
+	}
+
+	// Throw away non HEC conform data
+	fieldsJSON, _ := json.Marshal(GetHECEvent(logEvent))
+
+	logger.Debug("fieldsJSON: %s", fieldsJSON)
+
+	bodyHEC, err := postHTTP("hec", stage.Connection.URL, map[string]string{
+		"Authorization": stage.Connection.Authentication,
+		"Content-Type":  "application/json",
+	}, fieldsJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.InfoWith("HEC", "response Body", string(bodyHEC))
+
+	return []LogEvent{logEvent}, nil
+}
+
+// NewHTTPOutputStage creates the stage posting events to url
+func NewHTTPOutputStage(url string) *HTTPOutputStage {
+	return &HTTPOutputStage{URL: url}
+}
+
+// Process posts the JSON encoded event
+func (stage *HTTPOutputStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if err := checkMasked(logEvent, stage.Unmasked, logger); err != nil {
+		return nil, err
+	}
+
+	logEventJSON, _ := json.Marshal(logEvent)
+
+	body, err := postHTTP("http", stage.URL, map[string]string{"Content-Type": "application/json"}, logEventJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.DebugWith("HTTP output", "response Body", string(body))
+
+	return []LogEvent{logEvent}, nil
+}
+
+// NewStreamOutputStage creates the stage putting events into a v3io stream
+func NewStreamOutputStage(url string, streamName string, raw bool) *StreamOutputStage {
+	return &StreamOutputStage{URL: url, StreamName: streamName, Raw: raw}
+}
+
+// Process puts the event into the stream, the sender's address is passed as client info
+func (stage *StreamOutputStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if err := checkMasked(logEvent, stage.Unmasked, logger); err != nil {
+		return nil, err
+	}
+
+	data := []byte(logEvent.Event)
+	if !stage.Raw {
+		data, _ = json.Marshal(logEvent)
+	}
+
+	if stage.Typed && !stage.Raw {
+		data, _ = json.Marshal(TypedRecord{ContentType: LogEventContentType, Data: data})
+	}
+
+	streamRecord := StreamRecord{
+		StreamName: stage.StreamName,
+		Records: []Record{{
+			ClientInfo: base64.StdEncoding.EncodeToString([]byte(logEvent.Peer)),
+			Data:       base64.StdEncoding.EncodeToString(data),
+		}},
+	}
+
+	streamRecordJSON, _ := json.Marshal(streamRecord)
+
+	body, err := postHTTP("stream", stage.URL, map[string]string{
+		"Content-Type":    "application/json",
+		"X-v3io-function": "PutRecords",
+	}, streamRecordJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.DebugWith("Stream output", "response Body", string(body))
+
+	return []LogEvent{logEvent}, nil
+}
+
+// NewFileOutputStage creates the stage appending events to file
+func NewFileOutputStage(file *os.File) *FileOutputStage {
+	return &FileOutputStage{File: file}
+}
+
+// Process appends the event text to the file
+func (stage *FileOutputStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	if err := checkMasked(logEvent, stage.Unmasked, logger); err != nil {
+		return nil, err
+	}
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	if _, err := stage.File.WriteString(logEvent.Event + "\n"); err != nil {
+		return nil, err
+	}
+
+	return []LogEvent{logEvent}, stage.File.Sync()
+}
+
+// Function to refuse events which skipped masking, unless the output is a hop to the function masking them
+func checkMasked(logEvent LogEvent, unmasked bool, logger Logger) error {
+
+	if logEvent.masked || unmasked {
+		return nil
+	}
+
+	logger.Error("Event not masked, dropping")
+
+	return errors.New("Event not masked")
+}
+
+// Function to post body to url, returns the response body and an error for non 2xx responses, output labels the metrics
+func postHTTP(output string, url string, headers map[string]string, body []byte) ([]byte, error) {
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range headers {
+		if value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	start := time.Now()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+
+	metrics.Observe("output_request_seconds", time.Since(start).Seconds(), "output", output)
+
+	if err != nil {
+		// Connection errors and timeouts have no status
+		metrics.Inc("output_requests_total", "output", output, "status", "error")
+		metrics.Inc("output_errors_total", "output", output, "status", "error")
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	status := strconv.Itoa(resp.StatusCode)
+
+	metrics.Inc("output_requests_total", "output", output, "status", status)
+	metrics.Add("output_bytes_total", float64(len(body)), "output", output)
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 300 {
+		metrics.Inc("output_errors_total", "output", output, "status", status)
+		return respBody, fmt.Errorf("%s: %s", resp.Status, respBody)
+	}
+
+	return respBody, nil
+}
@@ -0,0 +1,404 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/v3io/v3io-go-http"
+	"gopkg.in/yaml.v2"
+)
+
+// PipelineConfigVersion is the only supported version of the pipeline config
+const PipelineConfigVersion = 1
+
+// PipelineConfig Struct, describes input, stages and outputs of a function or daemon
+type PipelineConfig struct {
+	Version int            `yaml:"version" json:"version"`
+	Name    string         `yaml:"name" json:"name"`
+	Input   InputConfig    `yaml:"input" json:"input"`
+	Stages  []StageConfig  `yaml:"stages" json:"stages"`
+	Outputs []OutputConfig `yaml:"outputs" json:"outputs"`
+}
+
+// InputConfig Struct
+type InputConfig struct {
+	Type        string `yaml:"type" json:"type"`
+	Address     string `yaml:"address" json:"address"`
+	Timeout     string `yaml:"timeout" json:"timeout"`
+	LineBreaker string `yaml:"linebreaker" json:"linebreaker"`
+
+	// Address tcp inputs serve /metrics on, functions serve it on their HTTP trigger
+	MetricsAddress string `yaml:"metricsaddress" json:"metricsaddress"`
+
+	// Defaults for the Event-Output-Mode and Field-Prefix-Mode headers
+	EventOutputMode string `yaml:"eventoutputmode" json:"eventoutputmode"`
+	FieldPrefixMode string `yaml:"fieldprefixmode" json:"fieldprefixmode"`
+}
+
+// StageConfig Struct
+type StageConfig struct {
+	Type string `yaml:"type" json:"type"`
+}
+
+// OutputConfig Struct
+type OutputConfig struct {
+	Type          string `yaml:"type" json:"type"`
+	URL           string `yaml:"url" json:"url"`
+	Authorization string `yaml:"authorization" json:"authorization"`
+	Stream        string `yaml:"stream" json:"stream"`
+	Raw           bool   `yaml:"raw" json:"raw"`
+	Typed         bool   `yaml:"typed" json:"typed"`
+	Path          string `yaml:"path" json:"path"`
+
+	// Send events without a redact stage, only for http and stream hops to a function masking them
+	Unmasked bool `yaml:"unmasked" json:"unmasked"`
+}
+
+// Stages which can be named in a pipeline config, and whether they need the configuration below /conf/
+var stageTypes = map[string]bool{
+	"envelope":  false,
+	"classify":  true,
+	"route":     true,
+	"extract":   true,
+	"calc":      true,
+	"normalize": true,
+	"filter":    true,
+	"dedup":     true,
+	"sample":    true,
+	"lookup":    true,
+	"geoip":     true,
+	"host":      true,
+	"redact":    true,
+	"aggregate": true,
+	"format":    false,
+	"meta":      false,
+}
+
+// Stages adding fields, masking has to run after them
+var fieldStageTypes = map[string]bool{"classify": true, "extract": true, "calc": true, "normalize": true, "lookup": true, "geoip": true, "host": true, "meta": true}
+
+var stageTypeNames = []string{"envelope", "classify", "route", "extract", "calc", "normalize", "filter", "dedup", "sample", "lookup", "geoip", "host", "redact", "aggregate", "format", "meta"}
+
+// IsStageType returns true if name can be used as type of a stage
+func IsStageType(name string) bool {
+	_, ok := stageTypes[name]
+	return ok
+}
+
+// LoadPipelineConfig loads the pipeline config from location, a file path or v3io:<path>, or parses defaultConfig if location is empty
+func LoadPipelineConfig(location string, container *v3io.Container, defaultConfig string) (*PipelineConfig, error) {
+
+	if location == "" {
+		return ParsePipelineConfig([]byte(defaultConfig), "default pipeline config")
+	}
+
+	if strings.HasPrefix(location, "v3io:") {
+		return LoadPipelineConfigV3IO(container, strings.TrimPrefix(location, "v3io:"))
+	}
+
+	return LoadPipelineConfigFile(location)
+}
+
+// LoadPipelineConfigFile loads the pipeline config from a YAML or JSON file
+func LoadPipelineConfigFile(path string) (*PipelineConfig, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline config %s: %v", path, err)
+	}
+
+	return ParsePipelineConfig(data, path)
+}
+
+// LoadPipelineConfigV3IO loads the pipeline config from a v3io object, e.g. /conf/pipelines/fieldextractor2.yaml
+func LoadPipelineConfigV3IO(container *v3io.Container, path string) (*PipelineConfig, error) {
+
+	if container == nil {
+		return nil, fmt.Errorf("pipeline config v3io:%s: no v3io container available", path)
+	}
+
+	GetObjectResponse, GetObjecterr := container.Sync.GetObject(&v3io.GetObjectInput{
+		Path: path})
+	if GetObjecterr != nil {
+		return nil, fmt.Errorf("pipeline config v3io:%s: %v", path, GetObjecterr)
+	}
+
+	return ParsePipelineConfig(GetObjectResponse.Body(), "v3io:"+path)
+}
+
+// ParsePipelineConfig parses and validates a YAML or JSON pipeline config, unknown keys are errors
+func ParsePipelineConfig(data []byte, source string) (*PipelineConfig, error) {
+
+	var pipelineConfig PipelineConfig
+
+	var err error
+
+	// JSON is parsed as such, YAML does not allow the tabs JSON files are often indented with
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&pipelineConfig)
+	} else {
+		err = yaml.UnmarshalStrict(data, &pipelineConfig)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("pipeline config %s: %v", source, err)
+	}
+
+	if problems := pipelineConfig.Validate(); len(problems) > 0 {
+		return nil, fmt.Errorf("pipeline config %s is invalid:\n  %s", source, strings.Join(problems, "\n  "))
+	}
+
+	// Header defaults as before the pipeline config existed
+	if pipelineConfig.Input.EventOutputMode == "" {
+		pipelineConfig.Input.EventOutputMode = "normal"
+	}
+	if pipelineConfig.Input.FieldPrefixMode == "" {
+		pipelineConfig.Input.FieldPrefixMode = "prefix"
+	}
+
+	return &pipelineConfig, nil
+}
+
+// Validate checks the config against the schema, returning one message per problem
+func (pipelineConfig *PipelineConfig) Validate() []string {
+
+	var problems []string
+
+	if pipelineConfig.Version != PipelineConfigVersion {
+		problems = append(problems, fmt.Sprintf("version: has to be %d, got %d", PipelineConfigVersion, pipelineConfig.Version))
+	}
+
+	input := pipelineConfig.Input
+
+	switch input.Type {
+	case "tcp", "http", "stream":
+	default:
+		problems = append(problems, fmt.Sprintf("input.type: has to be tcp, http or stream, got %q", input.Type))
+	}
+
+	if input.Timeout != "" {
+		if _, err := time.ParseDuration(input.Timeout); err != nil {
+			problems = append(problems, fmt.Sprintf("input.timeout: %v", err))
+		}
+	}
+
+	if input.LineBreaker != "" {
+		if input.Type != "tcp" {
+			problems = append(problems, "input.linebreaker: only supported by tcp inputs")
+		}
+		if _, err := regexp.Compile(input.LineBreaker); err != nil {
+			problems = append(problems, fmt.Sprintf("input.linebreaker: %v", err))
+		}
+	}
+
+	if input.MetricsAddress != "" {
+		if input.Type != "tcp" {
+			problems = append(problems, "input.metricsaddress: only supported by tcp inputs")
+		}
+		if _, _, err := net.SplitHostPort(input.MetricsAddress); err != nil {
+			problems = append(problems, fmt.Sprintf("input.metricsaddress: %v", err))
+		}
+	}
+
+	switch input.EventOutputMode {
+	case "", "normal", "minimal", "kv", "none":
+	default:
+		problems = append(problems, fmt.Sprintf("input.eventoutputmode: has to be normal, minimal, kv or none, got %q", input.EventOutputMode))
+	}
+
+	switch input.FieldPrefixMode {
+	case "", "normal", "prefix":
+	default:
+		problems = append(problems, fmt.Sprintf("input.fieldprefixmode: has to be normal or prefix, got %q", input.FieldPrefixMode))
+	}
+
+	redacted := false
+
+	for i, stageConfig := range pipelineConfig.Stages {
+		if _, ok := stageTypes[stageConfig.Type]; !ok {
+			problems = append(problems, fmt.Sprintf("stages[%d].type: unknown stage %q, has to be one of %s", i, stageConfig.Type, strings.Join(stageTypeNames, ", ")))
+		}
+		if stageConfig.Type == "redact" {
+			redacted = true
+		}
+	}
+
+	// Fields added after the last redact stage would leave unmasked
+	if redacted {
+		lastRedact := 0
+		for i, stageConfig := range pipelineConfig.Stages {
+			if stageConfig.Type == "redact" {
+				lastRedact = i
+			}
+		}
+		for i, stageConfig := range pipelineConfig.Stages[lastRedact+1:] {
+			if fieldStageTypes[stageConfig.Type] {
+				problems = append(problems, fmt.Sprintf("stages[%d]: %s adds fields after the redact stage, move it before redact", lastRedact+1+i, stageConfig.Type))
+			}
+		}
+	}
+
+	if len(pipelineConfig.Outputs) == 0 {
+		problems = append(problems, "outputs: at least one output is required")
+	}
+
+	for i, outputConfig := range pipelineConfig.Outputs {
+
+		// Events which skipped masking are never sent, unless to the function masking them
+		if !redacted && !outputConfig.Unmasked {
+			problems = append(problems, fmt.Sprintf("outputs[%d]: %s output requires a redact stage, or unmasked: true for hops to a function masking the events", i, outputConfig.Type))
+		}
+
+		switch outputConfig.Type {
+		case "hec":
+			if outputConfig.Unmasked {
+				problems = append(problems, fmt.Sprintf("outputs[%d].unmasked: not allowed for hec outputs", i))
+			}
+		case "http":
+			if outputConfig.URL == "" {
+				problems = append(problems, fmt.Sprintf("outputs[%d].url: required for http outputs", i))
+			}
+		case "stream":
+			if outputConfig.URL == "" {
+				problems = append(problems, fmt.Sprintf("outputs[%d].url: required for stream outputs", i))
+			}
+			if outputConfig.Stream == "" {
+				problems = append(problems, fmt.Sprintf("outputs[%d].stream: required for stream outputs", i))
+			}
+			if outputConfig.Typed && outputConfig.Raw {
+				problems = append(problems, fmt.Sprintf("outputs[%d].typed: raw events can't be typed", i))
+			}
+		case "file":
+			if outputConfig.Path == "" {
+				problems = append(problems, fmt.Sprintf("outputs[%d].path: required for file outputs", i))
+			}
+			if outputConfig.Unmasked {
+				problems = append(problems, fmt.Sprintf("outputs[%d].unmasked: not allowed for file outputs", i))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("outputs[%d].type: has to be hec, http, stream or file, got %q", i, outputConfig.Type))
+		}
+
+		if outputConfig.URL != "" {
+			if parsedURL, err := url.Parse(outputConfig.URL); err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+				problems = append(problems, fmt.Sprintf("outputs[%d].url: %q is not an absolute URL", i, outputConfig.URL))
+			}
+		}
+	}
+
+	return problems
+}
+
+// NeedsConfig returns true if any stage or output needs the configuration below /conf/
+func (pipelineConfig *PipelineConfig) NeedsConfig() bool {
+
+	for _, stageConfig := range pipelineConfig.Stages {
+		if stageTypes[stageConfig.Type] {
+			return true
+		}
+	}
+
+	for _, outputConfig := range pipelineConfig.Outputs {
+		if outputConfig.Type == "hec" && outputConfig.URL == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Build creates the pipeline, config may be nil if no stage needs it
+func (pipelineConfig *PipelineConfig) Build(config *Config, logger Logger) (*Pipeline, error) {
+
+	if config == nil && pipelineConfig.NeedsConfig() {
+		return nil, fmt.Errorf("pipeline %s needs the configuration below /conf/", pipelineConfig.Name)
+	}
+
+	var stages []Stage
+
+	for _, stageConfig := range pipelineConfig.Stages {
+
+		switch stageConfig.Type {
+		case "envelope":
+			stages = append(stages, NewEnvelopeStage())
+		case "classify":
+			stages = append(stages, NewClassifyStage(config))
+		case "route":
+			stages = append(stages, NewRouteStage(config))
+		case "extract":
+			stages = append(stages, NewExtractStage(config))
+		case "calc":
+			stages = append(stages, NewCalcStage(config))
+		case "normalize":
+			stages = append(stages, NewNormalizeStage(config))
+		case "filter":
+			stages = append(stages, NewFilterStage(config))
+		case "dedup":
+			stages = append(stages, NewDedupStage(config, logger))
+		case "sample":
+			stages = append(stages, NewSampleStage(config))
+		case "lookup":
+			stages = append(stages, NewLookupStage(config))
+		case "geoip":
+			stages = append(stages, NewGeoIPStage(config))
+		case "host":
+			stages = append(stages, NewHostStage(config))
+		case "redact":
+			stages = append(stages, NewRedactStage(config))
+		case "aggregate":
+			stages = append(stages, NewAggregateStage(config, logger))
+		case "format":
+			stages = append(stages, NewFormatStage())
+		case "meta":
+			stages = append(stages, NewMetaStage())
+		}
+	}
+
+	var outputs []Stage
+
+	for _, outputConfig := range pipelineConfig.Outputs {
+
+		switch outputConfig.Type {
+		case "hec":
+			// Connection defaults to /conf/outputs/hec/0
+			hecConnection := HECConnection{URL: outputConfig.URL, Authentication: outputConfig.Authorization}
+			if hecConnection.URL == "" {
+				hecConnection = config.HECConnection
+			}
+			outputs = append(outputs, &HECOutputStage{Connection: hecConnection})
+		case "http":
+			stage := NewHTTPOutputStage(outputConfig.URL)
+			stage.Unmasked = outputConfig.Unmasked
+			outputs = append(outputs, stage)
+		case "stream":
+			stage := NewStreamOutputStage(outputConfig.URL, outputConfig.Stream, outputConfig.Raw)
+			stage.Typed = outputConfig.Typed
+			stage.Unmasked = outputConfig.Unmasked
+			outputs = append(outputs, stage)
+		case "file":
+			file, err := os.OpenFile(outputConfig.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %s: %v", pipelineConfig.Name, err)
+			}
+			stage := NewFileOutputStage(file)
+			stage.Unmasked = outputConfig.Unmasked
+			outputs = append(outputs, stage)
+		}
+	}
+
+	pipeline := New(stages...)
+	pipeline.Name = pipelineConfig.Name
+	pipeline.Outputs = outputs
+
+	return pipeline, nil
+}
@@ -0,0 +1,244 @@
+package pipeline
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/v3io/v3io-go-http"
+)
+
+// RedactRule Struct
+type RedactRule struct {
+	Sourcetype  string   `json:"sourcetype"`
+	Class       string   `json:"class"`
+	Type        string   `json:"type"`
+	Regex       string   `json:"regex"`
+	Replacement string   `json:"replacement"`
+	Fields      []string `json:"fields"`
+	Event       bool     `json:"event"`
+	Salt        string   `json:"salt"`
+	Length      int      `json:"length"`
+	Table       string   `json:"table"`
+
+	regex *regexp.Regexp
+}
+
+// RedactStage Struct
+type RedactStage struct {
+	RedactRules []RedactRule
+
+	// Container for storing tokens of tokenize rules
+	Container *v3io.Container
+}
+
+// Maximum number of remembered stored tokens, the set is flushed when exceeded
+const tokenCacheSize = 100000
+
+// Tokens already stored in their token table
+var storedTokens = struct {
+	sync.Mutex
+	tokens map[string]bool
+}{tokens: map[string]bool{}}
+
+// Function to fetch redaction rules from /conf/props/<sourcetype>/redact/, invalid rules are errors as skipping them
+// would send the data they mask in clear text
+func getRedactRules(source ConfigSource, logger Logger) ([]RedactRule, error) {
+
+	var redactRules = make([]RedactRule, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/redact/", logger)
+
+		for item := range items {
+
+			redactRule := RedactRule{Sourcetype: sourcetype, Type: "sed"}
+
+			redactRule.Class, _ = items[item]["class"].(string)
+			redactRule.Regex, _ = items[item]["regex"].(string)
+			redactRule.Replacement, _ = items[item]["replacement"].(string)
+			redactRule.Salt, _ = items[item]["salt"].(string)
+			redactRule.Table, _ = items[item]["table"].(string)
+			redactRule.Event = getConfigBool(items[item]["event"])
+			redactRule.Length, _ = getConfigInt(items[item]["length"])
+
+			if redactType, ok := items[item]["type"].(string); ok && redactType != "" {
+				redactRule.Type = redactType
+			}
+
+			if fields, ok := items[item]["fields"].(string); ok {
+				redactRule.Fields = splitFieldList(fields)
+			}
+
+			// Rules without fields apply to the raw event
+			if len(redactRule.Fields) == 0 {
+				redactRule.Event = true
+			}
+
+			if redactRule.Regex != "" {
+				r, err := regexp.Compile(redactRule.Regex)
+				if err != nil {
+					return nil, fmt.Errorf("redact rule %s of %s: %v", redactRule.Class, sourcetype, err)
+				}
+				redactRule.regex = r
+			}
+
+			switch {
+			case redactRule.Type == "sed" && redactRule.regex == nil:
+				return nil, fmt.Errorf("redact rule %s of %s: type sed needs a regex", redactRule.Class, sourcetype)
+			case redactRule.Type == "truncate" && redactRule.Length == 0:
+				return nil, fmt.Errorf("redact rule %s of %s: type truncate needs a length", redactRule.Class, sourcetype)
+			case redactRule.Type != "sed" && redactRule.Type != "hash" && redactRule.Type != "truncate" && redactRule.Type != "tokenize":
+				return nil, fmt.Errorf("redact rule %s of %s: unknown type %s", redactRule.Class, sourcetype, redactRule.Type)
+			}
+
+			redactRules = append(redactRules, redactRule)
+		}
+	}
+
+	// Rules are applied in class order
+	sort.SliceStable(redactRules, func(i, j int) bool {
+		return redactRules[i].Class < redactRules[j].Class
+	})
+
+	return redactRules, nil
+}
+
+// NewRedactStage creates the stage masking sensitive data
+func NewRedactStage(config *Config) *RedactStage {
+	return &RedactStage{RedactRules: config.RedactRules, Container: config.Container}
+}
+
+// Process masks the event, has to run after every stage adding fields
+func (stage *RedactStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+	return []LogEvent{getRedactedEvent(stage.RedactRules, stage.Container, logEvent, logger)}, nil
+}
+
+// Function to mask the raw event and fields, has to run after every stage adding fields and before any output
+func getRedactedEvent(redactRules []RedactRule, container *v3io.Container, logEvent LogEvent, logger Logger) LogEvent {
+
+	for _, redactRule := range redactRules {
+
+		// Only apply rules of the event's sourcetype
+		if redactRule.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		if redactRule.Event {
+			logEvent.Event = redactRule.apply(container, logEvent.Event, logger)
+		}
+
+		for _, field := range redactRule.Fields {
+
+			key, ok := getEventFieldKey(logEvent, field)
+			if !ok {
+				continue
+			}
+
+			if value, ok := logEvent.Fields[key]; ok {
+				logEvent.Fields[key] = redactRule.apply(container, value, logger)
+			}
+
+			for i, value := range logEvent.MultiFields[key] {
+				logEvent.MultiFields[key][i] = redactRule.apply(container, value, logger)
+			}
+		}
+	}
+
+	logEvent.masked = true
+
+	return logEvent
+}
+
+// Function to apply a rule to a value, rules with regex only transform the matches
+func (redactRule RedactRule) apply(container *v3io.Container, value string, logger Logger) string {
+
+	if redactRule.Type == "sed" {
+		return redactRule.regex.ReplaceAllString(value, redactRule.Replacement)
+	}
+
+	if redactRule.regex == nil {
+		return redactRule.transform(container, value, logger)
+	}
+
+	return redactRule.regex.ReplaceAllStringFunc(value, func(match string) string {
+		return redactRule.transform(container, match, logger)
+	})
+}
+
+// Function to hash, truncate or tokenize a single value
+func (redactRule RedactRule) transform(container *v3io.Container, value string, logger Logger) string {
+
+	switch redactRule.Type {
+	case "hash":
+		return saltedHash(redactRule.Salt, value)
+
+	case "truncate":
+		runes := []rune(value)
+		// Positive lengths keep the beginning, negative lengths the end
+		if redactRule.Length > 0 && len(runes) > redactRule.Length {
+			return string(runes[:redactRule.Length])
+		}
+		if redactRule.Length < 0 && len(runes) > -redactRule.Length {
+			return string(runes[len(runes)+redactRule.Length:])
+		}
+		return value
+
+	case "tokenize":
+		token := "TOK-" + saltedHash(redactRule.Salt, value)[:16]
+		if redactRule.Table != "" {
+			storeToken(container, redactRule.Table, token, value, logger)
+		}
+		return token
+	}
+
+	return value
+}
+
+// Function to hash a value with HMAC-SHA256, keyed by the salt
+func saltedHash(salt string, value string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Function to store the original value of a token in a v3io KV table, so tokens can be reversed by authorized users
+func storeToken(container *v3io.Container, table string, token string, value string, logger Logger) {
+
+	// Tokens are still replaced without a container, e.g. when running offline, they just cannot be reversed
+	if container == nil {
+		return
+	}
+
+	storedTokens.Lock()
+	stored := storedTokens.tokens[token]
+	storedTokens.Unlock()
+
+	if stored {
+		return
+	}
+
+	// Not locked while writing, workers storing the same token at once just write the same item
+	err := container.Sync.PutItem(&v3io.PutItemInput{
+		Path:       strings.TrimSuffix(table, "/") + "/" + token,
+		Attributes: map[string]interface{}{"value": value}})
+	if err != nil {
+		logger.ErrorWith("Token store error", "table", table, "err", err)
+		return
+	}
+
+	storedTokens.Lock()
+	defer storedTokens.Unlock()
+
+	if len(storedTokens.tokens) >= tokenCacheSize {
+		storedTokens.tokens = map[string]bool{}
+	}
+
+	storedTokens.tokens[token] = true
+}
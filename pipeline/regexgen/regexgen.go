@@ -0,0 +1,478 @@
+// Package regexgen proposes named group regexes from sample events with the values of the fields to extract marked
+package regexgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Longest text before the first field used as anchor
+const maxAnchorLength = 24
+
+// Field value patterns tried in order, the first matching all values is used, %s is the character following the
+// field, patterns without it are only tried if no character follows or the character is not matched anyway
+var valuePatterns = []string{`\d+`, `\d+(?:\.\d+){3}`, `\w+`, `[\w.-]+`, `[^\s%s]+`, `\S+`, `[^%s]+`}
+
+// Field names have to be valid capture group names
+var fieldNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Example Struct, an event with the values of the fields to extract, events without fields are only validated against
+type Example struct {
+	Event  string          `json:"event"`
+	Fields map[string]Mark `json:"fields"`
+}
+
+// Mark Struct, a field value in the event, its first occurrence not taken by another field unless Start is given
+type Mark struct {
+	Value string `json:"value"`
+	Start *int   `json:"start,omitempty"`
+}
+
+// Result Struct, the fields extracted from an example, OK if they are the marked ones
+type Result struct {
+	Event    string            `json:"event"`
+	Marked   bool              `json:"marked"`
+	Matched  bool              `json:"matched"`
+	OK       bool              `json:"ok"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Expected map[string]string `json:"expected,omitempty"`
+}
+
+// Struct of a marked field located in its event
+type span struct {
+	name  string
+	start int
+	end   int
+}
+
+// UnmarshalJSON accepts the value alone, e.g. "10.0.0.1", or an object with value and start
+func (mark *Mark) UnmarshalJSON(data []byte) error {
+
+	if err := json.Unmarshal(data, &mark.Value); err == nil {
+		return nil
+	}
+
+	type plainMark Mark
+
+	return json.Unmarshal(data, (*plainMark)(mark))
+}
+
+// Generate proposes a regex extracting the marked fields of all examples, in the order they appear in the events
+func Generate(examples []Example) (string, error) {
+
+	var marked [][]span
+
+	for i, example := range examples {
+
+		if len(example.Fields) == 0 {
+			continue
+		}
+
+		spans, err := locate(example)
+		if err != nil {
+			return "", fmt.Errorf("example %d: %v", i+1, err)
+		}
+
+		if len(marked) > 0 && !sameOrder(marked[0], spans) {
+			return "", fmt.Errorf("example %d: fields appear in a different order than in the first marked example", i+1)
+		}
+
+		marked = append(marked, spans)
+	}
+
+	if len(marked) == 0 {
+		return "", fmt.Errorf("no example with marked fields")
+	}
+
+	var markedEvents []string
+	for _, example := range examples {
+		if len(example.Fields) > 0 {
+			markedEvents = append(markedEvents, example.Event)
+		}
+	}
+
+	var regex strings.Builder
+
+	// Text before the first field, its common end anchors the regex
+	var prefixes []string
+	for i, spans := range marked {
+		prefixes = append(prefixes, markedEvents[i][:spans[0].start])
+	}
+	anchor := commonSuffix(prefixes)
+	if allEqual(prefixes) && anchor == "" {
+		// Fields at the very start of every event
+		regex.WriteString("^")
+	} else if len(anchor) > maxAnchorLength {
+		anchor = anchor[runeStart(anchor, len(anchor)-maxAnchorLength):]
+		// Start the anchor at a word boundary
+		if i := strings.IndexAny(anchor, " \t"); i >= 0 && i < len(anchor)-1 {
+			anchor = anchor[i+1:]
+		}
+	}
+	regex.WriteString(literal(anchor))
+
+	for field := range marked[0] {
+
+		last := field+1 == len(marked[0])
+
+		var values, separators []string
+
+		for i, spans := range marked {
+			event := markedEvents[i]
+			values = append(values, event[spans[field].start:spans[field].end])
+			if !last {
+				separators = append(separators, event[spans[field].end:spans[field+1].start])
+			} else {
+				separators = append(separators, event[spans[field].end:])
+			}
+		}
+
+		// The character following the field limits its value
+		next := ""
+		if prefix := commonPrefix(separators); prefix != "" {
+			_, size := utf8.DecodeRuneInString(prefix)
+			next = prefix[:size]
+		}
+
+		pattern, err := valuePattern(values, next, last && next == "")
+		if err != nil {
+			return "", fmt.Errorf("field %s: %v", marked[0][field].name, err)
+		}
+
+		regex.WriteString("(?P<" + marked[0][field].name + ">" + pattern + ")")
+
+		if !last {
+			regex.WriteString(separator(separators))
+		} else if next != "" {
+			// Text following the last field up to the next space, e.g. a closing quote
+			trailing := commonPrefix(separators)
+			if i := strings.IndexAny(trailing, " \t"); i >= 0 {
+				trailing = trailing[:i]
+			}
+			if len(trailing) > maxAnchorLength {
+				trailing = trailing[:runeStart(trailing, maxAnchorLength)]
+			}
+			regex.WriteString(literal(trailing))
+		}
+	}
+
+	if _, err := regexp.Compile(regex.String()); err != nil {
+		return "", err
+	}
+
+	return regex.String(), nil
+}
+
+// Validate runs regex against all examples, the marked ones are OK if exactly the marked values are extracted
+func Validate(regex string, examples []Example) ([]Result, error) {
+
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+
+	for _, example := range examples {
+
+		result := Result{Event: example.Event, Marked: len(example.Fields) > 0}
+
+		if match := r.FindStringSubmatch(example.Event); match != nil {
+			result.Matched = true
+			result.Fields = map[string]string{}
+			for i, name := range r.SubexpNames() {
+				if i != 0 && name != "" {
+					result.Fields[name] = match[i]
+				}
+			}
+		}
+
+		if result.Marked {
+			result.Expected = map[string]string{}
+			for name, mark := range example.Fields {
+				result.Expected[name] = mark.Value
+			}
+			result.OK = result.Matched
+			for name, value := range result.Expected {
+				if result.Fields[name] != value {
+					result.OK = false
+				}
+			}
+		} else {
+			// Unmarked events are fine either way, they show what the regex would extract
+			result.OK = true
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Function to find the marked values in the event, ordered by position
+func locate(example Example) ([]span, error) {
+
+	var names []string
+	for name := range example.Fields {
+		if !fieldNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("field name %q has to be a word", name)
+		}
+		names = append(names, name)
+	}
+
+	var spans []span
+
+	// Fields with a position first, values without are searched in the remaining text
+	sort.Slice(names, func(i, j int) bool {
+		markI, markJ := example.Fields[names[i]], example.Fields[names[j]]
+		if (markI.Start == nil) != (markJ.Start == nil) {
+			return markI.Start != nil
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+
+		mark := example.Fields[name]
+		if mark.Value == "" {
+			return nil, fmt.Errorf("field %s: value missing", name)
+		}
+
+		start := -1
+		if mark.Start != nil {
+			start = *mark.Start
+			if start < 0 || start+len(mark.Value) > len(example.Event) || example.Event[start:start+len(mark.Value)] != mark.Value {
+				return nil, fmt.Errorf("field %s: %q not found at %d", name, mark.Value, start)
+			}
+		} else {
+			// Values standing alone are preferred, e.g. the 4 of "protocol 4" over the one of "%PIX-4-106023"
+			bestScore := -1
+			for offset := 0; offset <= len(example.Event); {
+				i := strings.Index(example.Event[offset:], mark.Value)
+				if i < 0 {
+					break
+				}
+				if score := boundaryScore(example.Event, offset+i, offset+i+len(mark.Value)); score > bestScore && !overlaps(spans, offset+i, offset+i+len(mark.Value)) {
+					start, bestScore = offset+i, score
+				}
+				offset += i + 1
+			}
+			if start < 0 {
+				return nil, fmt.Errorf("field %s: %q not found", name, mark.Value)
+			}
+		}
+
+		if overlaps(spans, start, start+len(mark.Value)) {
+			return nil, fmt.Errorf("field %s overlaps another field", name)
+		}
+
+		spans = append(spans, span{name: name, start: start, end: start + len(mark.Value)})
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	return spans, nil
+}
+
+// Function to rate how well a value at start to end is delimited, 2 after a space or separator, 1 at a word boundary
+func boundaryScore(event string, start int, end int) int {
+
+	isWord := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+	}
+
+	if (start > 0 && isWord(event[start-1]) && isWord(event[start])) || (end < len(event) && isWord(event[end-1]) && isWord(event[end])) {
+		return 0
+	}
+
+	if start == 0 || strings.IndexByte(" \t=:\"'([<,/", event[start-1]) >= 0 {
+		return 2
+	}
+
+	return 1
+}
+
+// Function to check if start to end overlaps one of the spans
+func overlaps(spans []span, start int, end int) bool {
+
+	for _, s := range spans {
+		if start < s.end && s.start < end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Function to check if the fields of two examples appear in the same order
+func sameOrder(a []span, b []span) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].name != b[i].name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Function to pick the most specific pattern matching all values of a field
+func valuePattern(values []string, next string, last bool) (string, error) {
+
+	for _, pattern := range valuePatterns {
+
+		if strings.Contains(pattern, "%s") {
+			if next == "" || strings.TrimSpace(next) == "" {
+				continue
+			}
+			pattern = fmt.Sprintf(pattern, regexp.QuoteMeta(next))
+		}
+
+		r, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return "", err
+		}
+
+		matchesAll := true
+		for _, value := range values {
+			if !r.MatchString(value) {
+				matchesAll = false
+				break
+			}
+		}
+
+		if matchesAll {
+			return pattern, nil
+		}
+	}
+
+	// Values spanning spaces run up to the following text, or to the end of the event
+	if last {
+		return `.+`, nil
+	}
+
+	return `.+?`, nil
+}
+
+// Function to build the pattern of the text between two fields, text differing between examples is skipped
+func separator(separators []string) string {
+
+	if allEqual(separators) {
+		return literal(separators[0])
+	}
+
+	prefix := commonPrefix(separators)
+	suffix := commonSuffix(separators)
+
+	// The common prefix and suffix may overlap in shorter separators
+	for _, s := range separators {
+		if len(prefix)+len(suffix) > len(s) {
+			suffix = suffix[len(prefix)+len(suffix)-len(s):]
+		}
+	}
+
+	return literal(prefix) + `.*?` + literal(suffix)
+}
+
+// Function to quote text, runs of whitespace match any whitespace
+func literal(text string) string {
+
+	var result strings.Builder
+
+	inSpace := false
+	for _, c := range text {
+		if c == ' ' || c == '\t' {
+			if !inSpace {
+				result.WriteString(`\s+`)
+			}
+			inSpace = true
+			continue
+		}
+		inSpace = false
+		result.WriteString(regexp.QuoteMeta(string(c)))
+	}
+
+	return result.String()
+}
+
+// Function to check if all strings are equal
+func allEqual(strs []string) bool {
+
+	for _, s := range strs {
+		if s != strs[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Function to get the longest common prefix of strings
+func commonPrefix(strs []string) string {
+
+	if len(strs) == 0 {
+		return ""
+	}
+
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	// Characters differing only in their last bytes are not common
+	return trimPartialRune(prefix)
+}
+
+// Function to get the longest common suffix of strings
+func commonSuffix(strs []string) string {
+
+	if len(strs) == 0 {
+		return ""
+	}
+
+	suffix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasSuffix(s, suffix) {
+			suffix = suffix[1:]
+		}
+	}
+
+	return suffix[runeStart(suffix, 0):]
+}
+
+// Function to move offset i of s forward to the start of a character, so slicing does not split multibyte characters
+func runeStart(s string, i int) int {
+
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+
+	return i
+}
+
+// Function to cut a multibyte character split at the end of s
+func trimPartialRune(s string) string {
+
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				return s[:i]
+			}
+			break
+		}
+	}
+
+	return s
+}
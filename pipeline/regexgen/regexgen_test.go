@@ -0,0 +1,175 @@
+package regexgen
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Function to build an example with fields marked by value
+func example(event string, fields ...string) Example {
+
+	marks := map[string]Mark{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		marks[fields[i]] = Mark{Value: fields[i+1]}
+	}
+
+	return Example{Event: event, Fields: marks}
+}
+
+func TestGenerate(t *testing.T) {
+
+	tests := []struct {
+		examples []Example
+		want     string
+	}{
+		{
+			[]Example{example("user=alice action=login", "user", "alice", "action", "login")},
+			`user=(?P<user>\w+)\s+action=(?P<action>\w+)`,
+		},
+		{
+			[]Example{
+				example("src 10.0.0.1 port 80", "src", "10.0.0.1", "port", "80"),
+				example("src 192.168.1.10 port 8080", "src", "192.168.1.10", "port", "8080"),
+			},
+			`src\s+(?P<src>\d+(?:\.\d+){3})\s+port\s+(?P<port>\d+)`,
+		},
+		{
+			[]Example{example("msg: hello world", "msg", "hello world")},
+			`msg:\s+(?P<msg>.+)`,
+		},
+		// The 4 standing alone is marked, not the one of %PIX-4-106023
+		{
+			[]Example{example("%PIX-4-106023: protocol 4", "protocol", "4")},
+			`%PIX-4-106023:\s+protocol\s+(?P<protocol>\d+)`,
+		},
+		// Multibyte characters around and in the values
+		{
+			[]Example{
+				example("Benutzer »müller« angemeldet", "user", "müller"),
+				example("Benutzer »möller« angemeldet", "user", "möller"),
+			},
+			`Benutzer\s+»(?P<user>[^\s«]+)«`,
+		},
+		// é and è share their first byte, which is no common prefix
+		{
+			[]Example{example("a é b", "v", "a"), example("a è b", "v", "a")},
+			`^(?P<v>\w+)`,
+		},
+	}
+
+	for _, test := range tests {
+
+		regex, err := Generate(test.examples)
+		if err != nil {
+			t.Errorf("%q: %v", test.examples[0].Event, err)
+			continue
+		}
+
+		if regex != test.want {
+			t.Errorf("%q: regex %s, want %s", test.examples[0].Event, regex, test.want)
+		}
+
+		results, err := Validate(regex, test.examples)
+		if err != nil {
+			t.Fatalf("Validate(%s): %v", regex, err)
+		}
+
+		for _, result := range results {
+			if !result.OK {
+				t.Errorf("%s extracts %v from %q, want %v", regex, result.Fields, result.Event, result.Expected)
+			}
+		}
+	}
+}
+
+func TestGenerateLongMultibyteAnchor(t *testing.T) {
+
+	event := strings.Repeat("é", 20) + "=1"
+
+	regex, err := Generate([]Example{example(event, "x", "1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !utf8.ValidString(regex) || !strings.HasSuffix(regex, "=(?P<x>\\d+)") || len(regex) > maxAnchorLength+len("=(?P<x>\\d+)") {
+		t.Errorf("regex %q, want a shortened anchor of whole characters", regex)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+
+	tests := []struct {
+		examples []Example
+		err      string
+	}{
+		{[]Example{{Event: "no fields"}}, "no example with marked fields"},
+		{[]Example{example("a=1", "a", "2")}, `"2" not found`},
+		{[]Example{example("a=1", "a-b", "1")}, "has to be a word"},
+		{[]Example{example("a=1", "a", "")}, "value missing"},
+		{[]Example{example("a=1 b=2", "a", "1", "b", "2"), example("b=2 a=1", "a", "1", "b", "2")}, "different order"},
+	}
+
+	for _, test := range tests {
+
+		_, err := Generate(test.examples)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: error %v, want %q", test.examples[0].Event, err, test.err)
+		}
+	}
+}
+
+func TestMarkStart(t *testing.T) {
+
+	var examples []Example
+	if err := json.Unmarshal([]byte(`[{"event": "a=1 b=1", "fields": {"b": {"value": "1", "start": 6}}}]`), &examples); err != nil {
+		t.Fatal(err)
+	}
+
+	regex, err := Generate(examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if regex != `a=1\s+b=(?P<b>\d+)` {
+		t.Errorf("regex %s, want the marked position of b", regex)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	examples := []Example{
+		example("user=alice", "user", "alice"),
+		example("user=bob", "user", "robert"),
+		{Event: "nothing"},
+	}
+
+	results, err := Validate(`user=(?P<user>\w+)`, examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !results[0].OK || results[1].OK || !results[2].OK || results[2].Matched {
+		t.Errorf("results %+v, want OK, not OK and an unmarked miss", results)
+	}
+
+	if _, err := Validate("(", examples); err == nil {
+		t.Error("no error for an invalid regex")
+	}
+}
+
+func TestCommonAffixes(t *testing.T) {
+
+	if prefix := commonPrefix([]string{"aé", "aè"}); prefix != "a" {
+		t.Errorf("commonPrefix %q, want a", prefix)
+	}
+
+	if suffix := commonSuffix([]string{"éa", "èa"}); suffix != "a" {
+		t.Errorf("commonSuffix %q, want a", suffix)
+	}
+
+	if start := runeStart("éa", 1); start != 2 {
+		t.Errorf("runeStart %d, want 2", start)
+	}
+}
@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"regexp"
+	"sort"
+)
+
+// TransformRule Struct
+type TransformRule struct {
+	Sourcetype string `json:"sourcetype"`
+	Class      string `json:"class"`
+	Regex      string `json:"regex"`
+	Field      string `json:"field"`
+	DestKey    string `json:"destkey"`
+	Format     string `json:"format"`
+
+	regex *regexp.Regexp
+}
+
+// RouteStage Struct
+type RouteStage struct {
+	TransformRules []TransformRule
+
+	// Extractions of the incoming sourcetype, for rules matching fields
+	RegexExtracts []RegexExtract
+	DelimExtracts []DelimExtract
+}
+
+// Function to fetch routing transforms from /conf/props/<sourcetype>/transforms/
+func getTransformRules(source ConfigSource, logger Logger) []TransformRule {
+
+	var transformRules = make([]TransformRule, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/transforms/", logger)
+
+		for item := range items {
+
+			transformRule := TransformRule{Sourcetype: sourcetype}
+
+			transformRule.Class, _ = items[item]["class"].(string)
+			transformRule.Regex, _ = items[item]["regex"].(string)
+			transformRule.Field, _ = items[item]["field"].(string)
+			transformRule.DestKey, _ = items[item]["destkey"].(string)
+			transformRule.Format, _ = items[item]["format"].(string)
+
+			switch transformRule.DestKey {
+			case "index", "sourcetype", "host", "source":
+			default:
+				logger.ErrorWith("Transform destkey has to be index, sourcetype, host or source", "sourcetype", sourcetype, "class", transformRule.Class, "destkey", transformRule.DestKey)
+				continue
+			}
+
+			r, err := regexp.Compile(transformRule.Regex)
+			if err != nil || transformRule.Regex == "" {
+				logger.ErrorWith("Transform regex error", "sourcetype", sourcetype, "class", transformRule.Class, "err", err)
+				continue
+			}
+
+			transformRule.regex = r
+
+			transformRules = append(transformRules, transformRule)
+		}
+	}
+
+	// Transforms are applied in class order
+	sort.SliceStable(transformRules, func(i, j int) bool {
+		return transformRules[i].Class < transformRules[j].Class
+	})
+
+	return transformRules
+}
+
+// NewRouteStage creates the stage rewriting index, sourcetype, host and source
+func NewRouteStage(config *Config) *RouteStage {
+	return &RouteStage{TransformRules: config.TransformRules, RegexExtracts: config.RegexExtracts, DelimExtracts: config.DelimExtracts}
+}
+
+// Process rewrites index, sourcetype, host or source before extraction, in a single pass over the incoming sourcetype's rules
+func (stage *RouteStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	sourcetype := logEvent.Sourcetype
+
+	// Fields of the incoming sourcetype, only extracted if a rule needs them
+	var fields map[string]string
+
+	for _, transformRule := range stage.TransformRules {
+
+		// Only apply transforms of the incoming sourcetype
+		if transformRule.Sourcetype != sourcetype {
+			continue
+		}
+
+		target := logEvent.Event
+
+		if transformRule.Field != "" {
+			if fields == nil {
+				extractEvent := logEvent
+				extractEvent.Sourcetype = sourcetype
+				extractEvent.Fields = map[string]string{}
+				extractEvent.MultiFields = map[string][]string{}
+				extractEvent.PrefixFields = false
+				// Not counted, the extract stage counts the extractions of the event
+				fields = extractEventFields(stage.RegexExtracts, stage.DelimExtracts, extractEvent, false, logger).Fields
+			}
+
+			value, ok := fields[transformRule.Field]
+			if !ok {
+				continue
+			}
+			target = value
+		}
+
+		match := transformRule.regex.FindStringSubmatchIndex(target)
+		if match == nil {
+			continue
+		}
+
+		// Format may reference capture groups as $1 or ${name}, an empty format takes the whole match
+		format := transformRule.Format
+		if format == "" {
+			format = "$0"
+		}
+
+		value := string(transformRule.regex.ExpandString(nil, format, target, match))
+
+		switch transformRule.DestKey {
+		case "index":
+			logEvent.Index = value
+		case "sourcetype":
+			logEvent.Sourcetype = value
+		case "host":
+			logEvent.Host = value
+		case "source":
+			logEvent.Source = value
+		}
+
+		metrics.Inc("events_routed_total", "sourcetype", sourcetype, "class", transformRule.Class)
+	}
+
+	return []LogEvent{logEvent}, nil
+}
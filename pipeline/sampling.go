@@ -0,0 +1,243 @@
+package pipeline
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SampleRule Struct
+type SampleRule struct {
+	Sourcetype string `json:"sourcetype"`
+	Class      string `json:"class"`
+	Rate       int    `json:"rate"`
+	Field      string `json:"field"`
+}
+
+// RateLimit Struct
+type RateLimit struct {
+	Sourcetype string   `json:"sourcetype"`
+	Class      string   `json:"class"`
+	By         []string `json:"by"`
+	Rate       float64  `json:"rate"`
+	Burst      float64  `json:"burst"`
+}
+
+// SampleStage Struct
+type SampleStage struct {
+	SampleRules []SampleRule
+	RateLimits  []RateLimit
+}
+
+// Maximum number of rate limit buckets, the buckets are reset when exceeded
+const rateLimitBucketSize = 10000
+
+// Event counters of counter based sampling and token buckets of rate limits
+var samplingState = struct {
+	sync.Mutex
+	counters map[string]int
+	buckets  map[string]*tokenBucket
+}{counters: map[string]int{}, buckets: map[string]*tokenBucket{}}
+
+// Function to fetch sampling rules from /conf/props/<sourcetype>/sample/
+func getSampleRules(source ConfigSource, logger Logger) []SampleRule {
+
+	var sampleRules = make([]SampleRule, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/sample/", logger)
+
+		for item := range items {
+
+			sampleRule := SampleRule{Sourcetype: sourcetype}
+
+			sampleRule.Class, _ = items[item]["class"].(string)
+			sampleRule.Field, _ = items[item]["field"].(string)
+			sampleRule.Rate, _ = getConfigInt(items[item]["rate"])
+
+			if sampleRule.Rate < 1 {
+				logger.ErrorWith("Sample rule needs a rate of 1 or more", "sourcetype", sourcetype, "class", sampleRule.Class)
+				continue
+			}
+
+			sampleRules = append(sampleRules, sampleRule)
+		}
+	}
+
+	return sampleRules
+}
+
+// Function to fetch rate limits from /conf/props/<sourcetype>/ratelimit/
+func getRateLimits(source ConfigSource, logger Logger) []RateLimit {
+
+	var rateLimits = make([]RateLimit, 0)
+
+	for _, sourcetype := range getSourcetypes(source, logger) {
+
+		items := getConfigItems(source, "conf/props/"+sourcetype+"/ratelimit/", logger)
+
+		for item := range items {
+
+			rateLimit := RateLimit{Sourcetype: sourcetype}
+
+			rateLimit.Class, _ = items[item]["class"].(string)
+
+			// Limits apply per host by default
+			rateLimit.By = []string{"host"}
+			if by, ok := items[item]["by"].(string); ok && by != "" {
+				rateLimit.By = splitFieldList(by)
+			}
+
+			byValid := true
+			for _, by := range rateLimit.By {
+				switch by {
+				case "host", "source", "index", "sourcetype":
+				default:
+					logger.ErrorWith("Rate limit by has to be host, source, index or sourcetype", "sourcetype", sourcetype, "class", rateLimit.Class, "by", by)
+					byValid = false
+				}
+			}
+
+			if !byValid {
+				continue
+			}
+
+			// Rates may be fractional, e.g. 0.5 for one event every two seconds
+			rateLimit.Rate, _ = getConfigFloat(items[item]["rate"])
+
+			// Bursts default to the rate, but hold at least one event
+			rateLimit.Burst = math.Max(rateLimit.Rate, 1)
+			if burst, ok := getConfigFloat(items[item]["burst"]); ok {
+				rateLimit.Burst = burst
+			}
+
+			if rateLimit.Rate <= 0 || rateLimit.Burst < 1 {
+				logger.ErrorWith("Rate limit needs a positive rate and a burst of 1 or more", "sourcetype", sourcetype, "class", rateLimit.Class)
+				continue
+			}
+
+			rateLimits = append(rateLimits, rateLimit)
+		}
+	}
+
+	return rateLimits
+}
+
+// NewSampleStage creates the stage sampling and rate limiting events
+func NewSampleStage(config *Config) *SampleStage {
+	return &SampleStage{SampleRules: config.SampleRules, RateLimits: config.RateLimits}
+}
+
+// Process samples and rate limits events, kept events carry their sampling rate in sample_rate
+func (stage *SampleStage) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	sampleRate := 1
+
+	for _, sampleRule := range stage.SampleRules {
+
+		// Only apply rules of the event's sourcetype
+		if sampleRule.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		keep, sampled := sampleRule.keep(logEvent)
+		if !keep {
+			metrics.Inc("events_sampled_out_total", "sourcetype", logEvent.Sourcetype, "class", sampleRule.Class)
+			logger.Debug("Event sampled out")
+			return nil, nil
+		}
+
+		// Events passed unsampled stand only for themselves
+		if sampled {
+			sampleRate = sampleRate * sampleRule.Rate
+		}
+	}
+
+	for _, rateLimit := range stage.RateLimits {
+
+		// Only apply limits of the event's sourcetype
+		if rateLimit.Sourcetype != logEvent.Sourcetype {
+			continue
+		}
+
+		if !rateLimit.allow(logEvent) {
+			metrics.Inc("events_ratelimited_total", "sourcetype", logEvent.Sourcetype, "class", rateLimit.Class)
+			logger.Debug("Event rate limited")
+			return nil, nil
+		}
+	}
+
+	if sampleRate > 1 {
+		logEvent = addEventFields(logEvent, map[string]string{"sample_rate": strconv.Itoa(sampleRate)})
+	}
+
+	return []LogEvent{logEvent}, nil
+}
+
+// Function to keep 1 in rate events, by hash of the field value or by counting, returns if the event is kept and if
+// it was sampled at all, events without the hash field are kept unsampled
+func (sampleRule SampleRule) keep(logEvent LogEvent) (bool, bool) {
+
+	if sampleRule.Rate == 1 {
+		return true, true
+	}
+
+	// Hash based sampling keeps or drops all events with the same field value
+	if sampleRule.Field != "" {
+		value, ok := getEventField(logEvent, sampleRule.Field)
+		if !ok {
+			return true, false
+		}
+
+		hash := fnv.New32a()
+		hash.Write([]byte(value))
+
+		return hash.Sum32()%uint32(sampleRule.Rate) == 0, true
+	}
+
+	samplingState.Lock()
+	defer samplingState.Unlock()
+
+	key := sampleRule.Sourcetype + "|" + sampleRule.Class
+
+	samplingState.counters[key] = (samplingState.counters[key] + 1) % sampleRule.Rate
+
+	return samplingState.counters[key] == 1%sampleRule.Rate, true
+}
+
+// Function to take a token from the bucket of the event's host, sourcetype or index
+func (rateLimit RateLimit) allow(logEvent LogEvent) bool {
+
+	keys := []string{rateLimit.Sourcetype, rateLimit.Class}
+
+	for _, by := range rateLimit.By {
+		switch by {
+		case "host":
+			keys = append(keys, logEvent.Host)
+		case "source":
+			keys = append(keys, logEvent.Source)
+		case "index":
+			keys = append(keys, logEvent.Index)
+		case "sourcetype":
+			keys = append(keys, logEvent.Sourcetype)
+		}
+	}
+
+	key := strings.Join(keys, "|")
+
+	samplingState.Lock()
+	bucket, ok := samplingState.buckets[key]
+	if !ok {
+		if len(samplingState.buckets) >= rateLimitBucketSize {
+			samplingState.buckets = map[string]*tokenBucket{}
+		}
+		bucket = newTokenBucket(rateLimit.Rate, rateLimit.Burst)
+		samplingState.buckets[key] = bucket
+	}
+	samplingState.Unlock()
+
+	return bucket.Allow()
+}
@@ -0,0 +1,178 @@
+// Package pipeline holds the LogEvent and the stages shared by the tcpinput daemons and the nuclio functions
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Logger interface, satisfied by the nuclio logger and by StdLogger
+type Logger interface {
+	Error(format interface{}, vars ...interface{})
+	Warn(format interface{}, vars ...interface{})
+	Info(format interface{}, vars ...interface{})
+	Debug(format interface{}, vars ...interface{})
+	ErrorWith(format interface{}, vars ...interface{})
+	WarnWith(format interface{}, vars ...interface{})
+	InfoWith(format interface{}, vars ...interface{})
+	DebugWith(format interface{}, vars ...interface{})
+}
+
+// Stage interface, a stage turns one event into zero or more events, returning none drops the event
+type Stage interface {
+	Process(logEvent LogEvent, logger Logger) ([]LogEvent, error)
+}
+
+// StageFunc adapts a plain function to a Stage
+type StageFunc func(logEvent LogEvent, logger Logger) ([]LogEvent, error)
+
+// Process calls the function
+func (stageFunc StageFunc) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+	return stageFunc(logEvent, logger)
+}
+
+// Pipeline Struct, Name labels its metrics
+type Pipeline struct {
+	Name   string
+	Stages []Stage
+
+	// Every event left after the stages is sent to all outputs, a failing output doesn't keep it from the others
+	Outputs []Stage
+}
+
+// New creates a pipeline running the stages in the given order
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{Stages: stages}
+}
+
+// Process runs an event through all stages, every event returned by a stage is passed to the next one, and sends the
+// resulting events to all outputs. The events are returned along with the errors of the outputs that failed.
+func (pipeline *Pipeline) Process(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+
+	start := time.Now()
+	defer func() {
+		metrics.Observe("pipeline_duration_seconds", time.Since(start).Seconds(), "pipeline", pipeline.Name)
+	}()
+
+	metrics.Inc("events_in_total", "pipeline", pipeline.Name)
+	metrics.Add("event_bytes_in_total", float64(len(logEvent.Event)), "pipeline", pipeline.Name)
+
+	logEvents := []LogEvent{logEvent}
+
+	for _, stage := range pipeline.Stages {
+
+		var nextEvents []LogEvent
+
+		for _, logEvent := range logEvents {
+			processed, err := stage.Process(logEvent, logger)
+			if err != nil {
+				metrics.Inc("pipeline_errors_total", "pipeline", pipeline.Name, "stage", stageName(stage))
+				return nil, err
+			}
+			if len(processed) == 0 {
+				metrics.Inc("pipeline_events_dropped_total", "pipeline", pipeline.Name, "stage", stageName(stage))
+			}
+			nextEvents = append(nextEvents, processed...)
+		}
+
+		// Nothing left to process
+		if len(nextEvents) == 0 {
+			return nil, nil
+		}
+
+		logEvents = nextEvents
+	}
+
+	var outputErrors []string
+
+	for _, logEvent := range logEvents {
+		for _, output := range pipeline.Outputs {
+			if _, err := output.Process(logEvent, logger); err != nil {
+				metrics.Inc("pipeline_errors_total", "pipeline", pipeline.Name, "stage", stageName(output))
+				outputErrors = append(outputErrors, fmt.Sprintf("%s: %v", stageName(output), err))
+			}
+		}
+	}
+
+	metrics.Add("events_out_total", float64(len(logEvents)), "pipeline", pipeline.Name)
+
+	if len(outputErrors) > 0 {
+		return logEvents, fmt.Errorf("%d output errors: %s", len(outputErrors), strings.Join(outputErrors, "; "))
+	}
+
+	return logEvents, nil
+}
+
+// Close stops the background work of the stages having any, like expiring shared dedup state
+func (pipeline *Pipeline) Close() {
+
+	for _, stage := range append(pipeline.Stages, pipeline.Outputs...) {
+		if closer, ok := stage.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// Function to name a stage by its type for metric labels, e.g. *pipeline.HECOutputStage is hecoutput
+func stageName(stage Stage) string {
+
+	name := fmt.Sprintf("%T", stage)
+	name = name[strings.LastIndex(name, ".")+1:]
+
+	return strings.ToLower(strings.TrimSuffix(name, "Stage"))
+}
+
+// StdLogger Struct, logs to stdout for the daemons running outside of nuclio
+type StdLogger struct {
+	Verbose bool
+}
+
+// Error logs an error
+func (logger StdLogger) Error(format interface{}, vars ...interface{}) {
+	logger.log("ERROR", format, vars)
+}
+
+// Warn logs a warning
+func (logger StdLogger) Warn(format interface{}, vars ...interface{}) {
+	logger.log("WARN", format, vars)
+}
+
+// Info logs an info message
+func (logger StdLogger) Info(format interface{}, vars ...interface{}) {
+	logger.log("INFO", format, vars)
+}
+
+// Debug logs a debug message, only if verbose
+func (logger StdLogger) Debug(format interface{}, vars ...interface{}) {
+	if logger.Verbose {
+		logger.log("DEBUG", format, vars)
+	}
+}
+
+// ErrorWith logs an error with key/value pairs
+func (logger StdLogger) ErrorWith(format interface{}, vars ...interface{}) {
+	logger.log("ERROR", format, vars)
+}
+
+// WarnWith logs a warning with key/value pairs
+func (logger StdLogger) WarnWith(format interface{}, vars ...interface{}) {
+	logger.log("WARN", format, vars)
+}
+
+// InfoWith logs an info message with key/value pairs
+func (logger StdLogger) InfoWith(format interface{}, vars ...interface{}) {
+	logger.log("INFO", format, vars)
+}
+
+// DebugWith logs a debug message with key/value pairs, only if verbose
+func (logger StdLogger) DebugWith(format interface{}, vars ...interface{}) {
+	if logger.Verbose {
+		logger.log("DEBUG", format, vars)
+	}
+}
+
+func (logger StdLogger) log(level string, format interface{}, vars []interface{}) {
+	log.Println(append([]interface{}{level, fmt.Sprint(format)}, vars...)...)
+}
@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPipelineOutputsFanOut(t *testing.T) {
+
+	var sent []string
+
+	output := func(name string, err error) Stage {
+		return StageFunc(func(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+			sent = append(sent, name+":"+logEvent.Event)
+			return []LogEvent{logEvent}, err
+		})
+	}
+
+	// Every event is split in two before the outputs
+	split := StageFunc(func(logEvent LogEvent, logger Logger) ([]LogEvent, error) {
+		first, second := logEvent, logEvent
+		first.Event, second.Event = "a", "b"
+		return []LogEvent{first, second}, nil
+	})
+
+	testPipeline := New(split)
+	testPipeline.Outputs = []Stage{output("failing", errors.New("unavailable")), output("working", nil)}
+
+	logEvents, err := testPipeline.Process(NewLogEvent(), StdLogger{})
+
+	if err == nil || !strings.Contains(err.Error(), "2 output errors") || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("error %v, want the 2 errors of the failing output", err)
+	}
+
+	if len(logEvents) != 2 {
+		t.Errorf("%d events returned, want both events of the split", len(logEvents))
+	}
+
+	want := "failing:a working:a failing:b working:b"
+	if got := strings.Join(sent, " "); got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+}
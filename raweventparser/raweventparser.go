@@ -1,12 +1,73 @@
 package main
 
-import nuclio "github.com/nuclio/nuclio-sdk-go"
+import (
+	"os"
+
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
+	nuclio "github.com/nuclio/nuclio-sdk-go"
+	"github.com/v3io/v3io-go-http"
+)
+
+// Pipeline used unless PIPELINE_CONFIG names a file or v3io:<path>
+const defaultPipelineConfig = `
+version: 1
+name: raweventparser
+input:
+  type: stream
+stages:
+  - type: envelope
+outputs:
+  - type: http
+    url: http://fieldextractor2.lcsystems:8080
+    # Events are masked by fieldextractor2
+    unmasked: true
+`
+
+var parserPipeline *pipeline.Pipeline
+
+// InitContext for setting up function
+func InitContext(context *nuclio.Context) error {
+
+	// The data binding is only needed for configs stored in v3io
+	container, _ := context.DataBinding["db0"].(*v3io.Container)
+
+	pipelineConfig, err := pipeline.LoadPipelineConfig(os.Getenv("PIPELINE_CONFIG"), container, defaultPipelineConfig)
+	if err != nil {
+		context.Logger.ErrorWith("Pipeline config error", "err", err)
+		return err
+	}
+
+	var config *pipeline.Config
+	if pipelineConfig.NeedsConfig() && container != nil {
+		config, err = pipeline.LoadConfig(container, context.Logger)
+		if err != nil {
+			context.Logger.ErrorWith("Config error", "err", err)
+			return err
+		}
+	}
+
+	parserPipeline, err = pipelineConfig.Build(config, context.Logger)
+	if err != nil {
+		context.Logger.ErrorWith("Pipeline build error", "err", err)
+		return err
+	}
+
+	return nil
+}
 
 // Handler for Stream events
 func Handler(context *nuclio.Context, event nuclio.Event) (interface{}, error) {
-	println(event.GetTotalNumShards())
 
-	return nuclio.Response{}
+	// Typed LogEvents put by tcpinput4 carry the peer, other producers put the raw event text
+	logEvent := pipeline.ParseStreamRecord(event.GetBody())
+
+	if logEvent.Event == "" {
+		return nil, nil
+	}
+
+	_, err := parserPipeline.Process(logEvent, context.Logger)
+
+	return nil, err
 }
 
 func main() {
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// ConfigDocument Struct, the export format read back by import and diff
+type ConfigDocument struct {
+	Version int `json:"version"`
+
+	// Sourcetype, section and class of the items below /conf/props/
+	Props map[string]map[string]map[string]Attributes `json:"props,omitempty"`
+
+	// Items of /conf/outputs/<name>/0
+	Outputs map[string]Attributes `json:"outputs,omitempty"`
+
+	// Items of /conf/lookups/ and /conf/networks/
+	Lookups  map[string]Attributes `json:"lookups,omitempty"`
+	Networks map[string]Attributes `json:"networks,omitempty"`
+}
+
+// Change Struct, one item to add, update or delete
+type Change struct {
+	Op   string
+	Path string
+	Old  Attributes
+	New  Attributes
+}
+
+// Sections below /conf/props/<sourcetype>/ read by fieldextractor2, routes are its transforms
+var propsSections = []string{"classify", "extract", "delims", "transforms", "eval", "alias", "fields", "lookup", "geoip", "filter", "dedup", "sample", "ratelimit", "redact", "metrics"}
+
+// Function to check if name is a section below /conf/props/<sourcetype>/
+func isPropsSection(name string) bool {
+
+	for _, section := range propsSections {
+		if section == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Function to read a document from a JSON file
+func readDocument(path string) (*ConfigDocument, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document ConfigDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if document.Version != 1 {
+		return nil, fmt.Errorf("%s: version has to be 1", path)
+	}
+
+	for sourcetype, sections := range document.Props {
+		for section := range sections {
+			if !isPropsSection(section) {
+				return nil, fmt.Errorf("%s: unknown section %s of sourcetype %s", path, section, sourcetype)
+			}
+		}
+	}
+
+	return &document, nil
+}
+
+// Function to export the live config, limited to one sourcetype if given
+func exportDocument(v3ioClient *V3IOClient, sourcetype string) (*ConfigDocument, error) {
+
+	document := &ConfigDocument{Version: 1, Props: map[string]map[string]map[string]Attributes{}}
+
+	sourcetypes := []string{sourcetype}
+	if sourcetype == "" {
+		var err error
+		if sourcetypes, err = v3ioClient.ListDirs("conf/props/"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sourcetype := range sourcetypes {
+		for _, section := range propsSections {
+
+			items, err := v3ioClient.GetItems("conf/props/" + sourcetype + "/" + section + "/")
+			if err != nil {
+				return nil, err
+			}
+
+			if len(items) == 0 {
+				continue
+			}
+
+			// The class attribute is the key of the item
+			for class, attributes := range items {
+				if attributes["class"] == class {
+					delete(attributes, "class")
+				}
+			}
+
+			if document.Props[sourcetype] == nil {
+				document.Props[sourcetype] = map[string]map[string]Attributes{}
+			}
+			document.Props[sourcetype][section] = items
+		}
+	}
+
+	// Outputs, lookups and networks are not specific to a sourcetype
+	if sourcetype != "" {
+		return document, nil
+	}
+
+	outputs, err := v3ioClient.ListDirs("conf/outputs/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, output := range outputs {
+		attributes, err := v3ioClient.GetItem("conf/outputs/" + output + "/0")
+		if err != nil {
+			return nil, err
+		}
+		if attributes != nil {
+			if document.Outputs == nil {
+				document.Outputs = map[string]Attributes{}
+			}
+			document.Outputs[output] = attributes
+		}
+	}
+
+	if document.Lookups, err = v3ioClient.GetItems("conf/lookups/"); err != nil {
+		return nil, err
+	}
+
+	if document.Networks, err = v3ioClient.GetItems("conf/networks/"); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// Function to flatten a document into items by path, props items get their class attribute
+func flattenDocument(document *ConfigDocument) map[string]Attributes {
+
+	items := map[string]Attributes{}
+
+	for sourcetype, sections := range document.Props {
+		for section, classes := range sections {
+			for class, attributes := range classes {
+				item := Attributes{"class": class}
+				for name, value := range attributes {
+					item[name] = value
+				}
+				items["conf/props/"+sourcetype+"/"+section+"/"+class] = item
+			}
+		}
+	}
+
+	for output, attributes := range document.Outputs {
+		items["conf/outputs/"+output+"/0"] = attributes
+	}
+
+	for name, attributes := range document.Lookups {
+		items["conf/lookups/"+name] = attributes
+	}
+
+	for name, attributes := range document.Networks {
+		items["conf/networks/"+name] = attributes
+	}
+
+	return items
+}
+
+// Function to list the changes turning live into desired, deletes are limited to the directories desired covers
+func diffDocuments(live *ConfigDocument, desired *ConfigDocument) []Change {
+	return diffItems(flattenDocument(live), flattenDocument(desired), coveredDirs(desired))
+}
+
+// Function to get the directories a document defines completely, the sections it names and lookups and networks
+func coveredDirs(document *ConfigDocument) map[string]bool {
+
+	dirs := map[string]bool{}
+
+	for sourcetype, sections := range document.Props {
+		for section := range sections {
+			dirs["conf/props/"+sourcetype+"/"+section+"/"] = true
+		}
+	}
+
+	if document.Lookups != nil {
+		dirs["conf/lookups/"] = true
+	}
+
+	if document.Networks != nil {
+		dirs["conf/networks/"] = true
+	}
+
+	return dirs
+}
+
+// Function to list the changes turning live items into desired items, live items are only deleted inside dirs
+func diffItems(liveItems map[string]Attributes, desiredItems map[string]Attributes, dirs map[string]bool) []Change {
+
+	var changes []Change
+
+	for path, attributes := range desiredItems {
+		old, ok := liveItems[path]
+		if !ok {
+			changes = append(changes, Change{Op: "add", Path: path, New: attributes})
+		} else if !equalAttributes(old, attributes) {
+			changes = append(changes, Change{Op: "update", Path: path, Old: old, New: attributes})
+		}
+	}
+
+	for path, attributes := range liveItems {
+		if _, ok := desiredItems[path]; ok {
+			continue
+		}
+		if dirs[path[:strings.LastIndex(path, "/")+1]] {
+			changes = append(changes, Change{Op: "delete", Path: path, Old: attributes})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// Function to compare attributes, numbers read from v3io and from JSON compare equal
+func equalAttributes(a Attributes, b Attributes) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for name, value := range a {
+		other, ok := b[name]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(other) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Function to print changes like a diff, one line per changed attribute
+func printChanges(changes []Change) {
+
+	for _, change := range changes {
+		switch change.Op {
+		case "add":
+			fmt.Printf("+ %s\n", change.Path)
+			printAttributes("    + ", change.New, nil)
+		case "delete":
+			fmt.Printf("- %s\n", change.Path)
+		case "update":
+			fmt.Printf("~ %s\n", change.Path)
+			printAttributes("    - ", change.Old, change.New)
+			printAttributes("    + ", change.New, change.Old)
+		}
+	}
+
+	adds, updates, deletes := 0, 0, 0
+	for _, change := range changes {
+		switch change.Op {
+		case "add":
+			adds++
+		case "update":
+			updates++
+		case "delete":
+			deletes++
+		}
+	}
+
+	fmt.Printf("%d to add, %d to update, %d to delete\n", adds, updates, deletes)
+}
+
+// Function to print the attributes differing from other, all if other is nil
+func printAttributes(prefix string, attributes Attributes, other Attributes) {
+
+	var names []string
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if other != nil {
+			if value, ok := other[name]; ok && fmt.Sprint(value) == fmt.Sprint(attributes[name]) {
+				continue
+			}
+		}
+		fmt.Printf("%s%s: %v\n", prefix, name, attributes[name])
+	}
+}
+
+// Function to apply changes, stops at the first failing change
+func applyChanges(v3ioClient *V3IOClient, changes []Change) error {
+
+	for i, change := range changes {
+
+		var err error
+
+		switch change.Op {
+		case "add", "update":
+			err = v3ioClient.PutItem(change.Path, change.New)
+		case "delete":
+			err = v3ioClient.DeleteItem(change.Path)
+		}
+
+		if err != nil {
+			// Changes are idempotent, running the command again applies the rest
+			return fmt.Errorf("%s %s: %v, %d of %d changes applied", change.Op, change.Path, err, i, len(changes))
+		}
+
+		fmt.Printf("%s %s\n", change.Op, change.Path)
+	}
+
+	return nil
+}
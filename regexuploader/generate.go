@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/my2ndhead/nuclio_event_etl/pipeline/regexgen"
+)
+
+// Function to propose a regex from examples with marked fields, writing it as extract class of the sourcetype
+func generateCommand(v3ioClient *V3IOClient, sourcetype string, class string, dryRun bool, samples map[string][]string, args []string) error {
+
+	if len(args) != 1 {
+		return fmt.Errorf("generate takes a file of examples")
+	}
+
+	examples, err := readExamples(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Sample events of the sourcetype are validated against too
+	for _, sample := range samples[sourcetype] {
+		examples = append(examples, regexgen.Example{Event: sample})
+	}
+
+	regex, err := regexgen.Generate(examples)
+	if err != nil {
+		return err
+	}
+
+	results, err := regexgen.Validate(regex, examples)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(regex)
+
+	failed, marked, matched := 0, 0, 0
+
+	for _, result := range results {
+
+		if !result.Marked {
+			if result.Matched {
+				matched++
+			} else {
+				fmt.Printf("unmatched %s\n", result.Event)
+			}
+			continue
+		}
+
+		marked++
+		if result.OK {
+			continue
+		}
+
+		failed++
+		fmt.Printf("FAIL      %s\n", result.Event)
+
+		var names []string
+		for name := range result.Expected {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if value, ok := result.Fields[name]; !ok {
+				fmt.Printf("  missing %s: %q\n", name, result.Expected[name])
+			} else if value != result.Expected[name] {
+				fmt.Printf("  changed %s: %q -> %q\n", name, result.Expected[name], value)
+			}
+		}
+	}
+
+	fmt.Printf("%d of %d marked examples extracted, %d of %d other events matched\n", marked-failed, marked, matched, len(results)-marked)
+
+	if failed > 0 {
+		return fmt.Errorf("the regex does not extract the marked values of %d examples, mark more examples or set start offsets", failed)
+	}
+
+	if sourcetype == "" || dryRun {
+		return nil
+	}
+
+	// Classes are named after their capture groups by default, like lines imported from a regex file
+	if class == "" {
+		class = strings.Join(regexp.MustCompile(regex).SubexpNames()[1:], ",")
+	}
+
+	path, err := itemPath("extract", sourcetype, class)
+	if err != nil {
+		return err
+	}
+
+	// The examples are checked as sample events too
+	lintSamples := map[string][]string{}
+	for st, events := range samples {
+		lintSamples[st] = events
+	}
+	for _, example := range examples {
+		if len(example.Fields) > 0 {
+			lintSamples[sourcetype] = append(lintSamples[sourcetype], example.Event)
+		}
+	}
+
+	return putItem(v3ioClient, sourcetype, path, Attributes{"class": class, "regex": regex}, lintSamples)
+}
+
+// Function to read one example or an array of them, an example is an event with the values of its fields
+func readExamples(path string) ([]regexgen.Example, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var examples []regexgen.Example
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &examples)
+	} else {
+		var example regexgen.Example
+		err = json.Unmarshal(data, &example)
+		examples = append(examples, example)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return examples, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem Struct, an error blocks writing, a warning is only reported
+type Problem struct {
+	Path    string
+	Error   bool
+	Message string
+}
+
+// RE2 never backtracks, but large programs still cost memory and time on every event
+const maxRegexLength = 8192
+const maxRegexInstructions = 20000
+
+// Time a regex may take on one sample event before it is reported
+const slowRegexTime = 10 * time.Millisecond
+
+// Sections whose items need a regex, as their stages skip items without
+var regexSections = map[string]bool{"extract": true, "transforms": true, "filter": true}
+
+// Function to read sample events from <sourcetype>.log files, one event per line
+func readSamples(dir string) (map[string][]string, error) {
+
+	samples := map[string][]string{}
+
+	if dir == "" {
+		return samples, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: no <sourcetype>.log files found", dir)
+	}
+
+	for _, path := range paths {
+
+		sourcetype, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".log"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				samples[sourcetype] = append(samples[sourcetype], line)
+			}
+		}
+	}
+
+	return samples, nil
+}
+
+// Function to lint items by path, problems are only reported for checked paths, all if checked is nil
+func lintItems(items map[string]Attributes, checked map[string]bool, samples map[string][]string) []Problem {
+
+	var problems []Problem
+
+	// Classes extracting each field, by sourcetype
+	fieldClasses := map[string]map[string][]string{}
+
+	for path, attributes := range items {
+
+		parts := strings.Split(path, "/")
+		if len(parts) != 5 || parts[1] != "props" {
+			continue
+		}
+		sourcetype, section, class := parts[2], parts[3], parts[4]
+
+		isChecked := checked == nil || checked[path]
+
+		var itemProblems []Problem
+		report := func(isError bool, format string, args ...interface{}) {
+			itemProblems = append(itemProblems, Problem{Path: path, Error: isError, Message: fmt.Sprintf(format, args...)})
+		}
+
+		var fields []string
+
+		r := lintRegex(section, attributes, report)
+		if r != nil {
+			fields = namedGroups(r)
+
+			if section == "extract" && len(fields) == 0 {
+				report(true, "no named capture groups, the extraction would not add any field")
+			}
+
+			if isChecked {
+				lintSamples(section, r, samples[sourcetype], report)
+			}
+		}
+
+		switch section {
+		case "delims":
+			for _, field := range strings.Split(attributeString(attributes, "fields"), ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					fields = append(fields, field)
+				}
+			}
+			if len(fields) == 0 {
+				report(true, "fields missing")
+			}
+		case "transforms":
+			switch attributeString(attributes, "destkey") {
+			case "index", "sourcetype", "host", "source":
+			default:
+				report(true, "destkey has to be index, sourcetype, host or source")
+			}
+		case "filter":
+			switch attributeString(attributes, "action") {
+			case "", "include", "exclude":
+			default:
+				report(true, "action has to be include or exclude")
+			}
+		case "redact":
+			switch attributeString(attributes, "type") {
+			case "", "sed":
+				if r == nil {
+					report(true, "sed rules need a regex")
+				}
+			case "hash", "tokenize", "truncate":
+			default:
+				report(true, "type has to be sed, hash, truncate or tokenize")
+			}
+		case "alias":
+			if attributeString(attributes, "field") == "" || attributeString(attributes, "alias") == "" {
+				report(true, "field and alias are required")
+			}
+		case "classify":
+			switch attributeString(attributes, "type") {
+			case "", "signature", "header":
+				if r == nil {
+					report(true, "signature and header rules need a regex")
+				}
+			case "json":
+				if attributeString(attributes, "keys") == "" {
+					report(true, "json rules need keys")
+				}
+			default:
+				report(true, "type has to be signature, header or json")
+			}
+			if confidence := attributeString(attributes, "confidence"); confidence != "" {
+				if value, err := strconv.ParseFloat(confidence, 64); err != nil || value < 1 || value > 100 {
+					report(true, "confidence has to be between 1 and 100")
+				}
+			}
+		case "eval":
+			if attributeString(attributes, "expression") == "" {
+				report(true, "expression missing")
+			}
+		}
+
+		if isChecked {
+			problems = append(problems, itemProblems...)
+		}
+
+		// Fields of both extractions end up in the same event
+		if section == "extract" || section == "delims" {
+			if fieldClasses[sourcetype] == nil {
+				fieldClasses[sourcetype] = map[string][]string{}
+			}
+			for _, field := range fields {
+				fieldClasses[sourcetype][field] = append(fieldClasses[sourcetype][field], section+"/"+class)
+			}
+		}
+	}
+
+	for sourcetype, classesByField := range fieldClasses {
+		for field, classes := range classesByField {
+
+			if len(classes) < 2 {
+				continue
+			}
+			sort.Strings(classes)
+
+			for _, class := range classes {
+				path := "conf/props/" + sourcetype + "/" + class
+				if checked == nil || checked[path] {
+					problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("field %s is extracted by %s, one value overwrites the other", field, strings.Join(classes, ", "))})
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Path < problems[j].Path
+	})
+
+	return problems
+}
+
+// Function to compile the regex attribute, reporting PCRE constructs and sizes, returns nil if there is none
+func lintRegex(section string, attributes Attributes, report func(bool, string, ...interface{})) *regexp.Regexp {
+
+	value, ok := attributes["regex"]
+	if !ok || value == "" {
+		if regexSections[section] {
+			report(true, "regex missing")
+		}
+		return nil
+	}
+
+	regex, ok := value.(string)
+	if !ok {
+		report(true, "regex has to be a string")
+		return nil
+	}
+
+	if len(regex) > maxRegexLength {
+		report(true, "regex is %d bytes long, the limit is %d", len(regex), maxRegexLength)
+		return nil
+	}
+
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		// Splunk regexes are PCRE, suggest the RE2 equivalent or name the construct RE2 lacks
+		if converted, _, convertErr := convertPCRE(regex); convertErr != nil && convertErr.Error() != err.Error() {
+			report(true, "not RE2 compatible: %v", convertErr)
+		} else if convertErr == nil && converted != regex {
+			report(true, "not RE2 compatible, try %s", converted)
+		} else {
+			report(true, "%v", err)
+		}
+		return nil
+	}
+
+	parsed, err := syntax.Parse(regex, syntax.Perl)
+	if err == nil {
+		if prog, err := syntax.Compile(parsed.Simplify()); err == nil && len(prog.Inst) > maxRegexInstructions {
+			report(true, "regex compiles to %d instructions, the limit is %d, avoid large counted repetitions", len(prog.Inst), maxRegexInstructions)
+			return nil
+		}
+	}
+
+	return r
+}
+
+// Function to run a regex against the sample events of its sourcetype
+func lintSamples(section string, r *regexp.Regexp, samples []string, report func(bool, string, ...interface{})) {
+
+	if len(samples) == 0 {
+		return
+	}
+
+	matches := 0
+	var slowest time.Duration
+
+	for _, sample := range samples {
+		start := time.Now()
+		if r.MatchString(sample) {
+			matches++
+		}
+		if elapsed := time.Since(start); elapsed > slowest {
+			slowest = elapsed
+		}
+	}
+
+	// Routing, filter and redact rules are expected to match only some events
+	if section == "extract" && matches == 0 {
+		report(false, "matches none of %d sample events", len(samples))
+	}
+
+	if slowest > slowRegexTime {
+		report(false, "takes %v on a sample event", slowest)
+	}
+}
+
+// Function to get an attribute as string, empty if missing
+func attributeString(attributes Attributes, name string) string {
+
+	if value, ok := attributes[name]; ok {
+		return fmt.Sprint(value)
+	}
+
+	return ""
+}
+
+// Function to print problems, returns the number of errors
+func reportProblems(problems []Problem) int {
+
+	errors := 0
+
+	for _, problem := range problems {
+		severity := "warning"
+		if problem.Error {
+			severity = "error"
+			errors++
+		}
+		fmt.Fprintf(os.Stderr, "%-7s %s: %s\n", severity, problem.Path, problem.Message)
+	}
+
+	return errors
+}
+
+// Function to lint the items live would have after changes, only the changed items are reported
+func checkChanges(liveItems map[string]Attributes, changes []Change, samples map[string][]string) error {
+
+	items := map[string]Attributes{}
+	for path, attributes := range liveItems {
+		items[path] = attributes
+	}
+
+	checked := map[string]bool{}
+
+	for _, change := range changes {
+		if change.Op == "delete" {
+			delete(items, change.Path)
+			continue
+		}
+		items[change.Path] = change.New
+		checked[change.Path] = true
+	}
+
+	if errors := reportProblems(lintItems(items, checked, samples)); errors > 0 {
+		return fmt.Errorf("%d errors found, nothing written", errors)
+	}
+
+	return nil
+}
+
+// Function to lint a document, a sync directory or the live config
+func validateCommand(v3ioClient *V3IOClient, sourcetype string, samples map[string][]string, args []string) error {
+
+	var document *ConfigDocument
+	var err error
+
+	switch {
+	case len(args) > 1:
+		return fmt.Errorf("validate takes a file, a directory or nothing for the live config")
+	case len(args) == 0:
+		document, err = exportDocument(v3ioClient, sourcetype)
+	default:
+		if info, statErr := os.Stat(args[0]); statErr == nil && info.IsDir() {
+			document, err = readSyncDir(args[0])
+		} else {
+			document, err = loadDocument(args[0], sourcetype)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	items := flattenDocument(document)
+	problems := lintItems(items, nil, samples)
+
+	if errors := reportProblems(problems); errors > 0 {
+		return fmt.Errorf("%d errors found", errors)
+	}
+
+	fmt.Printf("%d items valid, %d warnings\n", len(items), len(problems))
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Quantifier {n}, {n,} or {n,m} at the start of a string
+var quantifierRegex = regexp.MustCompile(`^\{\d+(,\d*)?\}`)
+
+// Function to convert a PCRE regex, as written in Splunk configs, to Go's RE2 syntax
+//
+// Returns the converted regex and notes on constructs that were rewritten with slightly different meaning.
+// Constructs RE2 cannot express, like lookarounds and backreferences, are errors.
+func convertPCRE(pcre string) (string, []string, error) {
+
+	var result strings.Builder
+	var notes []string
+
+	inClass := false
+
+	for i := 0; i < len(pcre); i++ {
+
+		c := pcre[i]
+
+		switch {
+		case c == '\\':
+			if i+1 == len(pcre) {
+				return "", nil, fmt.Errorf("trailing \\")
+			}
+			i++
+			e := pcre[i]
+
+			switch {
+			case e >= '1' && e <= '9' && !inClass:
+				return "", nil, fmt.Errorf("backreference \\%c not supported by RE2", e)
+			case e == 'g' || e == 'k':
+				return "", nil, fmt.Errorf("backreference \\%c not supported by RE2", e)
+			case e == 'K' || e == 'G':
+				return "", nil, fmt.Errorf("\\%c not supported by RE2", e)
+			case e == 'h' && inClass:
+				result.WriteString(` \t`)
+			case e == 'h':
+				result.WriteString(`[ \t]`)
+			case e == 'H' && !inClass:
+				result.WriteString(`[^ \t]`)
+			case e == 'R' && !inClass:
+				result.WriteString(`(?:\r\n|\n|\r)`)
+			case e == 'Z' && !inClass:
+				result.WriteString(`\n?\z`)
+				notes = append(notes, `\Z rewritten as \n?\z`)
+			case e == 'e':
+				result.WriteString(`\x1b`)
+			case e == 'H' || e == 'R' || e == 'Z':
+				return "", nil, fmt.Errorf("\\%c not supported in character classes", e)
+			case e == 'Q':
+				// Quoted text is copied up to \E, RE2 supports quoting
+				end := strings.Index(pcre[i:], `\E`)
+				if end < 0 {
+					result.WriteString(pcre[i-1:])
+					i = len(pcre)
+				} else {
+					result.WriteString(pcre[i-1 : i+end+2])
+					i += end + 1
+				}
+			default:
+				result.WriteByte('\\')
+				result.WriteByte(e)
+			}
+
+		case inClass:
+			if c == '[' && strings.HasPrefix(pcre[i:], "[:") {
+				// POSIX classes like [:alpha:] are copied as is
+				if end := strings.Index(pcre[i+2:], ":]"); end >= 0 {
+					result.WriteString(pcre[i : i+end+4])
+					i += end + 3
+					continue
+				}
+			}
+			if c == ']' {
+				inClass = false
+			}
+			result.WriteByte(c)
+
+		case c == '[':
+			inClass = true
+			result.WriteByte(c)
+			// A leading ] is a literal
+			if strings.HasPrefix(pcre[i+1:], "^]") {
+				result.WriteString("^]")
+				i += 2
+			} else if strings.HasPrefix(pcre[i+1:], "]") {
+				result.WriteString("]")
+				i++
+			}
+
+		case c == '(':
+			group, length, note, err := convertGroup(pcre[i:])
+			if err != nil {
+				return "", nil, err
+			}
+			if note != "" {
+				notes = append(notes, note)
+			}
+			result.WriteString(group)
+			i += length - 1
+
+		case c == '*' || c == '+' || c == '?' || c == '{':
+			quantifier := string(c)
+			if c == '{' {
+				quantifier = quantifierRegex.FindString(pcre[i:])
+				if quantifier == "" {
+					result.WriteByte(c)
+					continue
+				}
+			}
+			result.WriteString(quantifier)
+			i += len(quantifier) - 1
+
+			// Possessive quantifiers never backtrack, RE2 does not backtrack either but may match more
+			if strings.HasPrefix(pcre[i+1:], "+") {
+				notes = append(notes, "possessive quantifier "+quantifier+"+ rewritten as "+quantifier)
+				i++
+			}
+
+		default:
+			result.WriteByte(c)
+		}
+	}
+
+	converted := result.String()
+
+	if _, err := regexp.Compile(converted); err != nil {
+		return "", nil, err
+	}
+
+	return converted, notes, nil
+}
+
+// Function to convert the group starting at pattern, returns the converted opening and the length it replaces
+func convertGroup(pattern string) (string, int, string, error) {
+
+	if strings.HasPrefix(pattern, "(*") {
+		return "", 0, "", fmt.Errorf("verb %s not supported by RE2", pattern[:strings.IndexByte(pattern+")", ')')+1])
+	}
+
+	if !strings.HasPrefix(pattern, "(?") {
+		return "(", 1, "", nil
+	}
+
+	switch {
+	case strings.HasPrefix(pattern, "(?P<"), strings.HasPrefix(pattern, "(?:"):
+		return pattern[:3], 3, "", nil
+	case strings.HasPrefix(pattern, "(?="), strings.HasPrefix(pattern, "(?!"), strings.HasPrefix(pattern, "(?<="), strings.HasPrefix(pattern, "(?<!"):
+		return "", 0, "", fmt.Errorf("lookaround %s not supported by RE2", pattern[:strings.IndexAny(pattern[2:], "=!")+3])
+	case strings.HasPrefix(pattern, "(?>"):
+		return "(?:", 3, "atomic group (?> rewritten as (?:", nil
+	case strings.HasPrefix(pattern, "(?#"):
+		end := strings.IndexByte(pattern, ')')
+		if end < 0 {
+			return "", 0, "", fmt.Errorf("unterminated comment")
+		}
+		return "", end + 1, "", nil
+	case strings.HasPrefix(pattern, "(?<"), strings.HasPrefix(pattern, "(?'"):
+		closing := ">"
+		if pattern[2] == '\'' {
+			closing = "'"
+		}
+		end := strings.Index(pattern[3:], closing)
+		if end < 0 {
+			return "", 0, "", fmt.Errorf("unterminated group name")
+		}
+		name := pattern[3 : end+3]
+		// Splunk derives field names from _KEY_n and _VAL_n pairs at runtime
+		if strings.HasPrefix(name, "_KEY_") || strings.HasPrefix(name, "_VAL_") {
+			return "", 0, "", fmt.Errorf("dynamic field name %s not supported", name)
+		}
+		return "(?P<" + name + ">", end + 4, "", nil
+	case strings.HasPrefix(pattern, "(?P="), strings.HasPrefix(pattern, "(?P>"):
+		return "", 0, "", fmt.Errorf("backreference %s not supported by RE2", pattern[:4])
+	case strings.HasPrefix(pattern, "(?|"):
+		return "", 0, "", fmt.Errorf("branch reset (?| not supported by RE2")
+	case strings.HasPrefix(pattern, "(?("):
+		return "", 0, "", fmt.Errorf("conditional (?( not supported by RE2")
+	}
+
+	// Inline flags like (?i) or (?i:
+	end := strings.IndexAny(pattern[2:], ":)")
+	if end < 0 {
+		return "", 0, "", fmt.Errorf("unterminated group")
+	}
+
+	flags := pattern[2 : end+2]
+	for _, flag := range flags {
+		switch flag {
+		case 'i', 'm', 's', 'U', '-':
+		case 'R', '&', '+', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			return "", 0, "", fmt.Errorf("recursion (?%s) not supported by RE2", flags)
+		default:
+			return "", 0, "", fmt.Errorf("flag %c of (?%s) not supported by RE2", flag, flags)
+		}
+	}
+
+	return pattern[:end+3], end + 3, "", nil
+}
+
+// Function to name the capture groups of an RE2 regex by number, as Splunk's FORMAT = field::$1 does
+func nameGroups(regex string, names map[int]string) (string, error) {
+
+	var result strings.Builder
+
+	group := 0
+	inClass := false
+
+	for i := 0; i < len(regex); i++ {
+
+		c := regex[i]
+
+		switch {
+		case c == '\\' && i+1 < len(regex):
+			if regex[i+1] == 'Q' {
+				end := strings.Index(regex[i:], `\E`)
+				if end < 0 {
+					end = len(regex) - i - 2
+				}
+				result.WriteString(regex[i : i+end+2])
+				i += end + 1
+				continue
+			}
+			result.WriteString(regex[i : i+2])
+			i++
+		case inClass:
+			if end := strings.Index(regex[i:], ":]"); strings.HasPrefix(regex[i:], "[:") && end >= 0 {
+				result.WriteString(regex[i : i+end+2])
+				i += end + 1
+				continue
+			}
+			if c == ']' {
+				inClass = false
+			}
+			result.WriteByte(c)
+		case c == '[':
+			inClass = true
+			result.WriteByte(c)
+			if strings.HasPrefix(regex[i+1:], "^]") {
+				result.WriteString("^]")
+				i += 2
+			} else if strings.HasPrefix(regex[i+1:], "]") {
+				result.WriteString("]")
+				i++
+			}
+		case c == '(' && (strings.HasPrefix(regex[i:], "(?P<") || !strings.HasPrefix(regex[i:], "(?")):
+			group++
+			length := 1
+			if strings.HasPrefix(regex[i:], "(?P<") {
+				length = strings.IndexByte(regex[i:], '>') + 1
+			}
+			if name, ok := names[group]; ok {
+				result.WriteString("(?P<" + name + ">")
+			} else {
+				result.WriteString(regex[i : i+length])
+			}
+			i += length - 1
+		default:
+			result.WriteByte(c)
+		}
+	}
+
+	for number := range names {
+		if number > group {
+			return "", fmt.Errorf("$%d refers to a missing capture group", number)
+		}
+	}
+
+	return result.String(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertPCRE(t *testing.T) {
+
+	tests := []struct {
+		pcre  string
+		want  string
+		notes int
+	}{
+		{`(?<src>\d+\.\d+\.\d+\.\d+)`, `(?P<src>\d+\.\d+\.\d+\.\d+)`, 0},
+		{`(?'user'\w+)`, `(?P<user>\w+)`, 0},
+		{`(?P<user>\w+)`, `(?P<user>\w+)`, 0},
+		{`\h+\H+`, `[ \t]+[^ \t]+`, 0},
+		{`[\h,]`, `[ \t,]`, 0},
+		{`a\R`, `a(?:\r\n|\n|\r)`, 0},
+		{`\e\[0m`, `\x1b\[0m`, 0},
+		{`end\Z`, `end\n?\z`, 1},
+		{`(?>a+)b`, `(?:a+)b`, 1},
+		{`\d++x`, `\d+x`, 1},
+		{`a{2,}+`, `a{2,}`, 1},
+		{`a{x}`, `a{x}`, 0},
+		{`(?i)err(?#comment)or`, `(?i)error`, 0},
+		{`(?i:warn)`, `(?i:warn)`, 0},
+		{`\Q(?<x>\E\d`, `\Q(?<x>\E\d`, 0},
+		{`[]a]`, `[]a]`, 0},
+		{`[^]a]`, `[^]a]`, 0},
+		{`[[:alpha:]]+`, `[[:alpha:]]+`, 0},
+	}
+
+	for _, test := range tests {
+
+		regex, notes, err := convertPCRE(test.pcre)
+		if err != nil {
+			t.Errorf("%s: %v", test.pcre, err)
+			continue
+		}
+
+		if regex != test.want || len(notes) != test.notes {
+			t.Errorf("%s = %s with notes %q, want %s with %d notes", test.pcre, regex, notes, test.want, test.notes)
+		}
+	}
+}
+
+func TestConvertPCREErrors(t *testing.T) {
+
+	tests := []struct {
+		pcre string
+		err  string
+	}{
+		{`(\w+) \1`, "backreference"},
+		{`(?<a>x)\k<a>`, "backreference"},
+		{`(?P=a)`, "backreference"},
+		{`a(?=b)`, "lookaround (?="},
+		{`(?<!b)a`, "lookaround (?<!"},
+		{`a\Kb`, `\K not supported`},
+		{`(*UTF8)a`, "verb (*UTF8)"},
+		{`(?|a|b)`, "branch reset"},
+		{`(?(1)a|b)`, "conditional"},
+		{`(?R)`, "recursion"},
+		{`(?x)a`, "flag x"},
+		{`(?<_KEY_1>\w+)=(?<_VAL_1>\w+)`, "dynamic field name"},
+		{`[\R]`, "not supported in character classes"},
+		{`a\`, `trailing \`},
+		{`(a`, "missing closing )"},
+	}
+
+	for _, test := range tests {
+
+		_, _, err := convertPCRE(test.pcre)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.pcre, err, test.err)
+		}
+	}
+}
+
+func TestNameGroups(t *testing.T) {
+
+	tests := []struct {
+		regex string
+		names map[int]string
+		want  string
+	}{
+		{`(\w+)=(\d+)`, map[int]string{1: "key", 2: "value"}, `(?P<key>\w+)=(?P<value>\d+)`},
+		{`(?:a|b)(\w+)`, map[int]string{1: "x"}, `(?:a|b)(?P<x>\w+)`},
+		{`(?P<old>\w+) (\d+)`, map[int]string{1: "new"}, `(?P<new>\w+) (\d+)`},
+		{`[(](\d+)\Q(\E`, map[int]string{1: "n"}, `[(](?P<n>\d+)\Q(\E`},
+	}
+
+	for _, test := range tests {
+
+		regex, err := nameGroups(test.regex, test.names)
+		if err != nil || regex != test.want {
+			t.Errorf("%s = %s, %v, want %s", test.regex, regex, err, test.want)
+		}
+	}
+
+	if _, err := nameGroups(`(\w+)`, map[int]string{2: "missing"}); err == nil {
+		t.Error("no error for $2 of a regex with one group")
+	}
+}
@@ -2,96 +2,538 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 )
 
-type PutItem struct {
-	Item Item `json:"Item"`
+const usage = `Usage: regexuploader <command> [flags] [arguments]
+
+Manages the configuration below /conf/ read by fieldextractor2.
+
+Commands:
+  list [-sourcetype st] <kind>                      list sourcetypes, outputs, lookups, networks or classes of a section
+  get [-sourcetype st] <kind> <name>                print an item as JSON
+  put [-sourcetype st] <kind> <name> attr=value...  write an item, attr:=value takes JSON values (true, 60)
+  delete [-sourcetype st] <kind> <name>             delete an item, kind sourcetype deletes all its sections
+  export [-sourcetype st] [-o file]                 write the config as JSON document
+  import [-sourcetype st] [-dry-run] <file>         add and update the items of a JSON document, or of a file
+                                                    with one regex per line as extract classes of -sourcetype
+  diff [-sourcetype st] <file>                      show the changes importing a JSON document would make
+  sync [-dry-run] [-prune] <dir>                    make the sections of the sourcetypes in dir match their
+                                                    <sourcetype>.json files, adding, updating and deleting classes
+  validate [-sourcetype st] [file|dir]              check a document, a sync directory or the live config
+  generate [-sourcetype st] [-class name] [-dry-run] <file>
+                                                    propose a regex from example events with marked fields and
+                                                    validate it, with -sourcetype write it as extract class
+  splunk [-sourcetype st] [-dry-run] [-o file] <props.conf> [transforms.conf]
+                                                    import EXTRACT, REPORT, TRANSFORMS, SEDCMD and FIELDALIAS
+                                                    settings like import, or write them as JSON document to -o,
+                                                    reporting settings which could not be converted
+
+Every write is checked first: regexes have to be RE2, extractions need named groups, sizes are limited and
+with -samples every regex runs against the sample events of its sourcetype. Nothing is written on errors.
+
+Kinds: sourcetypes, outputs, lookups, networks, routes and the sections below /conf/props/<sourcetype>/:
+  ` + "classify, extract, delims, transforms, eval, alias, fields, lookup, geoip, filter, dedup, sample, ratelimit, redact, metrics" + `
+
+Flags:
+`
+
+func main() {
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	endpoint := flags.String("endpoint", getEnv("V3IO_ENDPOINT", "http://10.90.1.171:8081"), "v3io web API endpoint (V3IO_ENDPOINT)")
+	container := flags.String("container", getEnv("V3IO_CONTAINER", "splunk"), "v3io container (V3IO_CONTAINER)")
+	sourcetype := flags.String("sourcetype", "", "sourcetype below /conf/props/")
+	dryRun := flags.Bool("dry-run", false, "print the changes without applying them")
+	output := flags.String("o", "", "file to export to, default stdout, splunk writes the document instead of importing it")
+	prune := flags.Bool("prune", false, "sync: delete sourcetypes without file")
+	class := flags.String("class", "", "generate: extract class to write, default named after the capture groups")
+	samplesDir := flags.String("samples", "", "directory of <sourcetype>.log files with one sample event per line, every regex is run against")
+
+	flags.Parse(os.Args[2:])
+
+	args := flags.Args()
+
+	v3ioClient := NewV3IOClient(*endpoint, *container)
+
+	samples, err := readSamples(*samplesDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch command {
+	case "list":
+		err = listCommand(v3ioClient, *sourcetype, args)
+	case "get":
+		err = getCommand(v3ioClient, *sourcetype, args)
+	case "put":
+		err = putCommand(v3ioClient, *sourcetype, samples, args)
+	case "delete":
+		err = deleteCommand(v3ioClient, *sourcetype, args)
+	case "export":
+		err = exportCommand(v3ioClient, *sourcetype, *output)
+	case "import":
+		err = importCommand(v3ioClient, *sourcetype, *dryRun, samples, args)
+	case "diff":
+		err = diffCommand(v3ioClient, *sourcetype, samples, args)
+	case "sync":
+		err = syncCommand(v3ioClient, *dryRun, *prune, samples, args)
+	case "validate":
+		err = validateCommand(v3ioClient, *sourcetype, samples, args)
+	case "generate":
+		err = generateCommand(v3ioClient, *sourcetype, *class, *dryRun, samples, args)
+	case "splunk":
+		err = splunkCommand(v3ioClient, *sourcetype, *dryRun, *output, samples, args)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-type Item struct {
-	Class Class `json:"class"`
-	Regex Regex `json:"regex"`
+// Function to list sourcetypes, outputs or the items of a kind
+func listCommand(v3ioClient *V3IOClient, sourcetype string, args []string) error {
+
+	if len(args) != 1 {
+		return fmt.Errorf("list takes a kind")
+	}
+
+	var names []string
+
+	switch args[0] {
+	case "sourcetypes":
+		dirs, err := v3ioClient.ListDirs("conf/props/")
+		if err != nil {
+			return err
+		}
+		names = dirs
+	case "outputs":
+		dirs, err := v3ioClient.ListDirs("conf/outputs/")
+		if err != nil {
+			return err
+		}
+		names = dirs
+	default:
+		dir, err := kindDir(args[0], sourcetype)
+		if err != nil {
+			return err
+		}
+
+		items, err := v3ioClient.GetItems(dir)
+		if err != nil {
+			return err
+		}
+
+		for name, attributes := range items {
+			// Show the pattern next to extraction classes
+			if regex, ok := attributes["regex"]; ok {
+				name = name + "\t" + fmt.Sprint(regex)
+			}
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+
+	return nil
+}
+
+// Function to print an item as JSON
+func getCommand(v3ioClient *V3IOClient, sourcetype string, args []string) error {
+
+	if len(args) != 2 {
+		return fmt.Errorf("get takes a kind and a name")
+	}
+
+	path, err := itemPath(args[0], sourcetype, args[1])
+	if err != nil {
+		return err
+	}
+
+	attributes, err := v3ioClient.GetItem(path)
+	if err != nil {
+		return err
+	}
+	if attributes == nil {
+		return fmt.Errorf("%s not found", path)
+	}
+
+	return printJSON(os.Stdout, attributes)
 }
 
-type Class struct {
-	S string `json:"S"`
+// Function to write an item from attr=value and attr:=json arguments
+func putCommand(v3ioClient *V3IOClient, sourcetype string, samples map[string][]string, args []string) error {
+
+	if len(args) < 3 {
+		return fmt.Errorf("put takes a kind, a name and attributes")
+	}
+
+	path, err := itemPath(args[0], sourcetype, args[1])
+	if err != nil {
+		return err
+	}
+
+	attributes := Attributes{}
+
+	// Items below /conf/props/ carry their class
+	if strings.HasPrefix(path, "conf/props/") {
+		attributes["class"] = args[1]
+	}
+
+	for _, arg := range args[2:] {
+		if i := strings.Index(arg, ":="); i > 0 && i < strings.Index(arg+"=", "=") {
+			var value interface{}
+			if err := json.Unmarshal([]byte(arg[i+2:]), &value); err != nil {
+				return fmt.Errorf("%s: %v", arg, err)
+			}
+			attributes[arg[:i]] = value
+		} else if i := strings.Index(arg, "="); i > 0 {
+			attributes[arg[:i]] = arg[i+1:]
+		} else {
+			return fmt.Errorf("%s: attributes are given as attr=value or attr:=json", arg)
+		}
+	}
+
+	return putItem(v3ioClient, sourcetype, path, attributes, samples)
 }
 
-type Regex struct {
-	S string `json:"S"`
+// Function to write an item after checking it together with the other items of its sourcetype
+func putItem(v3ioClient *V3IOClient, sourcetype string, path string, attributes Attributes, samples map[string][]string) error {
+
+	// Items below /conf/props/ are checked together with the other items of their sourcetype
+	liveItems := map[string]Attributes{}
+	if strings.HasPrefix(path, "conf/props/") {
+		live, err := exportDocument(v3ioClient, sourcetype)
+		if err != nil {
+			return err
+		}
+		liveItems = flattenDocument(live)
+	}
+
+	if err := checkChanges(liveItems, []Change{{Op: "update", Path: path, New: attributes}}, samples); err != nil {
+		return err
+	}
+
+	if err := v3ioClient.PutItem(path, attributes); err != nil {
+		return err
+	}
+
+	fmt.Println("put", path)
+
+	return nil
 }
 
-func main() {
-	var putItem PutItem
-	var item Item
+// Function to delete an item, or all items of a sourcetype
+func deleteCommand(v3ioClient *V3IOClient, sourcetype string, args []string) error {
 
-	file, err := os.Open("/tmp/regexes_ciscoasa.txt")
+	if len(args) != 2 {
+		return fmt.Errorf("delete takes a kind and a name")
+	}
 
+	if args[0] == "sourcetype" || args[0] == "sourcetypes" {
+		document, err := exportDocument(v3ioClient, args[1])
+		if err != nil {
+			return err
+		}
+
+		var changes []Change
+		for path := range flattenDocument(document) {
+			changes = append(changes, Change{Op: "delete", Path: path})
+		}
+
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Path < changes[j].Path
+		})
+
+		return applyChanges(v3ioClient, changes)
+	}
+
+	path, err := itemPath(args[0], sourcetype, args[1])
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if err := v3ioClient.DeleteItem(path); err != nil {
+		return err
+	}
+
+	fmt.Println("delete", path)
+
+	return nil
+}
+
+// Function to write the live config as JSON document
+func exportCommand(v3ioClient *V3IOClient, sourcetype string, output string) error {
+
+	document, err := exportDocument(v3ioClient, sourcetype)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return printJSON(os.Stdout, document)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
 	}
 	defer file.Close()
 
+	return printJSON(file, document)
+}
+
+// Function to add and update the items of a document, nothing is deleted
+func importCommand(v3ioClient *V3IOClient, sourcetype string, dryRun bool, samples map[string][]string, args []string) error {
+
+	if len(args) != 1 {
+		return fmt.Errorf("import takes a file")
+	}
+
+	document, err := loadDocument(args[0], sourcetype)
+	if err != nil {
+		return err
+	}
+
+	return importDocument(v3ioClient, document, sourcetype, dryRun, samples)
+}
+
+// Function to add and update the items of a document, limited to sourcetype if given
+func importDocument(v3ioClient *V3IOClient, document *ConfigDocument, sourcetype string, dryRun bool, samples map[string][]string) error {
+
+	changes, liveItems, err := planChanges(v3ioClient, document, sourcetype)
+	if err != nil {
+		return err
+	}
+
+	// Import never deletes, use diff to see what is configured in addition
+	var writes []Change
+	for _, change := range changes {
+		if change.Op != "delete" {
+			writes = append(writes, change)
+		}
+	}
+
+	// All items are checked before anything is written
+	if err := checkChanges(liveItems, writes, samples); err != nil {
+		return err
+	}
+
+	if dryRun {
+		printChanges(writes)
+		return nil
+	}
+
+	return applyChanges(v3ioClient, writes)
+}
+
+// Function to show the changes between the live config and a document
+func diffCommand(v3ioClient *V3IOClient, sourcetype string, samples map[string][]string, args []string) error {
+
+	if len(args) != 1 {
+		return fmt.Errorf("diff takes a file")
+	}
+
+	document, err := loadDocument(args[0], sourcetype)
+	if err != nil {
+		return err
+	}
+
+	changes, liveItems, err := planChanges(v3ioClient, document, sourcetype)
+	if err != nil {
+		return err
+	}
+
+	printChanges(changes)
+
+	return checkChanges(liveItems, changes, samples)
+}
+
+// Function to diff a document against the live config of the same scope, returns the changes and the live items
+func planChanges(v3ioClient *V3IOClient, document *ConfigDocument, sourcetype string) ([]Change, map[string]Attributes, error) {
+
+	live, err := exportDocument(v3ioClient, sourcetype)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return diffDocuments(live, document), flattenDocument(live), nil
+}
+
+// Function to read a JSON document or a file with one regex per line, limited to sourcetype if given
+func loadDocument(path string, sourcetype string) (*ConfigDocument, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		return readRegexLines(path, sourcetype)
+	}
+
+	document, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if sourcetype != "" {
+		document = &ConfigDocument{Version: document.Version, Props: map[string]map[string]map[string]Attributes{
+			sourcetype: document.Props[sourcetype],
+		}}
+	}
+
+	return document, nil
+}
+
+// Function to read one regex per line as extract classes, named after their capture groups
+func readRegexLines(path string, sourcetype string) (*ConfigDocument, error) {
+
+	if sourcetype == "" {
+		return nil, fmt.Errorf("%s: regex files need -sourcetype", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	classes := map[string]Attributes{}
+
 	scanner := bufio.NewScanner(file)
 	i := 0
 	for scanner.Scan() {
 
 		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 
-		// Compiling regex
-		_, err := regexp.Compile(line)
+		// Classes are named after their fields, so reordering lines does not rename them, invalid regexes are
+		// reported by the checks before writing
+		var fields []string
+		if r, err := regexp.Compile(line); err == nil {
+			fields = namedGroups(r)
+		}
 
-		// Catching regex errors
-		if err != nil {
-			log.Fatal("Regex Error:", line)
+		class := strings.Join(fields, ",")
+		if class == "" {
+			class = strconv.Itoa(i)
+		}
+		for n := 2; classes[class] != nil; n++ {
+			class = strings.Join(fields, ",") + "-" + strconv.Itoa(n)
 		}
 
-		var class Class
-		class.S = strconv.Itoa(i)
+		classes[class] = Attributes{"regex": line}
+		i = i + 1
+	}
 
-		var regex Regex
-		regex.S = line
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-		item.Class = class
-		item.Regex = regex
-		putItem.Item = item
+	return &ConfigDocument{Version: 1, Props: map[string]map[string]map[string]Attributes{
+		sourcetype: {"extract": classes},
+	}}, nil
+}
 
-		jsonoutput, _ := json.Marshal(putItem)
+// Function to get the names of the named capture groups
+func namedGroups(r *regexp.Regexp) []string {
 
-		fmt.Println(string(jsonoutput))
+	var names []string
+	for _, name := range r.SubexpNames() {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
 
-		url := `http://10.90.1.171:8081/splunk/conf/props/cisco:asa/extract/` + strconv.Itoa(i)
+	return names
+}
 
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonoutput))
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-v3io-function", "PutItem")
+// Function to resolve the directory of a kind, sections below /conf/props/ need a sourcetype
+func kindDir(kind string, sourcetype string) (string, error) {
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			panic(err)
-		}
-		defer resp.Body.Close()
+	switch kind {
+	case "outputs", "output":
+		return "conf/outputs/", nil
+	case "lookups":
+		return "conf/lookups/", nil
+	case "networks":
+		return "conf/networks/", nil
+	case "routes", "route":
+		kind = "transforms"
+	}
 
-		fmt.Println("HEC", "response Status:: %s", resp.Status)
+	if !isPropsSection(kind) {
+		return "", fmt.Errorf("unknown kind %s", kind)
+	}
 
-		bodyHEC, _ := ioutil.ReadAll(resp.Body)
-		fmt.Println("HEC", "response Headers:: %s", bodyHEC)
+	if sourcetype == "" {
+		return "", fmt.Errorf("kind %s needs -sourcetype", kind)
+	}
 
-		i = i + 1
+	return "conf/props/" + sourcetype + "/" + kind + "/", nil
+}
+
+// Function to resolve the path of an item, outputs are stored as /conf/outputs/<name>/0
+func itemPath(kind string, sourcetype string, name string) (string, error) {
+
+	dir, err := kindDir(kind, sourcetype)
+	if err != nil {
+		return "", err
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	if dir == "conf/outputs/" {
+		return dir + name + "/0", nil
+	}
+
+	return dir + name, nil
+}
+
+// Function to print value as indented JSON
+func printJSON(file *os.File, value interface{}) error {
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	return encoder.Encode(value)
+}
+
+// Function to read an environment variable with default
+func getEnv(name string, defaultValue string) string {
+
+	if value := os.Getenv(name); value != "" {
+		return value
 	}
 
+	return defaultValue
 }
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// confStanza Struct, the settings of one [stanza] in file order
+type confStanza struct {
+	Name     string
+	Settings []confSetting
+}
+
+// confSetting Struct
+type confSetting struct {
+	Key   string
+	Value string
+}
+
+// splunkImport Struct, the document being built and the report of every setting
+type splunkImport struct {
+	Transforms map[string]map[string]string
+	Document   *ConfigDocument
+
+	converted int
+	changed   int
+	skipped   int
+}
+
+// Splunk field references in FORMAT, e.g. src::$1
+var formatFieldRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)::\$(\d+)$`)
+
+// Splunk capture group references in FORMAT and sed replacements
+var formatGroupRegex = regexp.MustCompile(`\$(\d+)`)
+var sedGroupRegex = regexp.MustCompile(`\\(\d)`)
+
+// Splunk's EXTRACT-<class> = <regex> in <field>
+var extractInRegex = regexp.MustCompile(`\s+in\s+[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Function to read a Splunk .conf file, settings before the first stanza belong to [default]
+func readConf(path string) ([]*confStanza, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stanza := &confStanza{Name: "default"}
+	stanzas := []*confStanza{stanza}
+	byName := map[string]*confStanza{"default": stanza}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	number := 0
+	line := ""
+	for scanner.Scan() {
+
+		number++
+
+		// A trailing backslash continues the setting on the next line
+		text := scanner.Text()
+		if strings.HasSuffix(text, `\`) && !strings.HasSuffix(text, `\\`) {
+			line += strings.TrimSuffix(text, `\`) + "\n"
+			continue
+		}
+		line += text
+
+		trimmed := strings.TrimSpace(line)
+		line = ""
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			name := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			// Stanzas given twice are merged, like Splunk does
+			if stanza = byName[name]; stanza == nil {
+				stanza = &confStanza{Name: name}
+				stanzas = append(stanzas, stanza)
+				byName[name] = stanza
+			}
+			continue
+		}
+
+		i := strings.Index(trimmed, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("%s:%d: expected [stanza] or key = value", path, number)
+		}
+
+		stanza.Settings = append(stanza.Settings, confSetting{
+			Key:   strings.TrimSpace(trimmed[:i]),
+			Value: strings.TrimSpace(trimmed[i+1:]),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return stanzas, nil
+}
+
+// Function to import props.conf and transforms.conf, writing the document or the items below /conf/props/
+func splunkCommand(v3ioClient *V3IOClient, sourcetype string, dryRun bool, output string, samples map[string][]string, args []string) error {
+
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("splunk takes props.conf and optionally transforms.conf")
+	}
+
+	props, err := readConf(args[0])
+	if err != nil {
+		return err
+	}
+
+	splunkImport := &splunkImport{
+		Transforms: map[string]map[string]string{},
+		Document:   &ConfigDocument{Version: 1, Props: map[string]map[string]map[string]Attributes{}},
+	}
+
+	if len(args) == 2 {
+		transforms, err := readConf(args[1])
+		if err != nil {
+			return err
+		}
+		for _, stanza := range transforms {
+			settings := map[string]string{}
+			for _, setting := range stanza.Settings {
+				settings[setting.Key] = setting.Value
+			}
+			splunkImport.Transforms[stanza.Name] = settings
+		}
+	}
+
+	for _, stanza := range props {
+		if sourcetype != "" && stanza.Name != sourcetype {
+			continue
+		}
+		splunkImport.importStanza(stanza)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d settings converted, %d of them changed, %d skipped\n", splunkImport.converted, splunkImport.changed, splunkImport.skipped)
+
+	document := splunkImport.Document
+
+	if output != "" {
+		if errors := reportProblems(lintItems(flattenDocument(document), nil, samples)); errors > 0 {
+			return fmt.Errorf("%d errors found, nothing written", errors)
+		}
+
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return printJSON(file, document)
+	}
+
+	if len(document.Props) == 0 {
+		return fmt.Errorf("nothing to import")
+	}
+
+	return importDocument(v3ioClient, document, sourcetype, dryRun, samples)
+}
+
+// Function to report a setting which was not imported
+func (splunkImport *splunkImport) skip(stanza string, key string, reason string) {
+	splunkImport.skipped++
+	fmt.Fprintf(os.Stderr, "skipped   [%s] %s: %s\n", stanza, key, reason)
+}
+
+// Function to report an imported setting with the notes of its conversion
+func (splunkImport *splunkImport) convert(stanza string, key string, notes []string) {
+	splunkImport.converted++
+	if len(notes) > 0 {
+		splunkImport.changed++
+		fmt.Fprintf(os.Stderr, "changed   [%s] %s: %s\n", stanza, key, strings.Join(notes, ", "))
+	}
+}
+
+// Function to add an item below /conf/props/<sourcetype>/<section>/
+func (splunkImport *splunkImport) add(sourcetype string, section string, class string, attributes Attributes) {
+
+	props := splunkImport.Document.Props
+	if props[sourcetype] == nil {
+		props[sourcetype] = map[string]map[string]Attributes{}
+	}
+	if props[sourcetype][section] == nil {
+		props[sourcetype][section] = map[string]Attributes{}
+	}
+
+	props[sourcetype][section][class] = attributes
+}
+
+// Function to import the settings of a props.conf stanza, stanzas are sourcetypes
+func (splunkImport *splunkImport) importStanza(stanza *confStanza) {
+
+	for _, prefix := range []string{"source::", "host::", "rule::", "delayedrule::"} {
+		if strings.HasPrefix(stanza.Name, prefix) {
+			for _, setting := range stanza.Settings {
+				splunkImport.skip(stanza.Name, setting.Key, "only sourcetype stanzas are supported")
+			}
+			return
+		}
+	}
+
+	if stanza.Name == "default" || stanza.Name == "" || strings.Contains(stanza.Name, "/") {
+		for _, setting := range stanza.Settings {
+			splunkImport.skip(stanza.Name, setting.Key, "only sourcetype stanzas are supported")
+		}
+		return
+	}
+
+	sourcetype := stanza.Name
+
+	for _, setting := range stanza.Settings {
+
+		key, value := setting.Key, setting.Value
+
+		prefix, class := key, ""
+		if i := strings.Index(key, "-"); i > 0 {
+			prefix, class = key[:i], strings.TrimSpace(key[i+1:])
+		}
+
+		if class == "" && (prefix == "EXTRACT" || prefix == "REPORT" || prefix == "TRANSFORMS" || prefix == "SEDCMD" || prefix == "FIELDALIAS") {
+			splunkImport.skip(sourcetype, key, "class name missing")
+			continue
+		}
+
+		switch prefix {
+		case "EXTRACT":
+			splunkImport.importExtract(sourcetype, key, class, value)
+		case "REPORT":
+			for _, name := range splitConfList(value) {
+				splunkImport.importReport(sourcetype, key, name)
+			}
+		case "TRANSFORMS":
+			for i, name := range splitConfList(value) {
+				// Transforms are applied in class order, like Splunk applies them in list order
+				splunkImport.importTransform(sourcetype, key, fmt.Sprintf("%s-%02d-%s", class, i, name), name)
+			}
+		case "SEDCMD":
+			splunkImport.importSedCmd(sourcetype, key, class, value)
+		case "FIELDALIAS":
+			splunkImport.importFieldAlias(sourcetype, key, class, value)
+		case "LINE_BREAKER":
+			splunkImport.skip(sourcetype, key, lineBreakerHint(value))
+		case "TIME_FORMAT", "TIME_PREFIX", "MAX_TIMESTAMP_LOOKAHEAD", "TZ":
+			splunkImport.skip(sourcetype, key, "timestamps are not parsed, events keep the time they were sent with")
+		default:
+			splunkImport.skip(sourcetype, key, "not supported")
+		}
+	}
+}
+
+// Function to import EXTRACT-<class> = <regex>
+func (splunkImport *splunkImport) importExtract(sourcetype string, key string, class string, value string) {
+
+	// EXTRACT-<class> = <regex> in <field> extracts from a field
+	if match := extractInRegex.FindString(value); match != "" {
+		splunkImport.skip(sourcetype, key, "extraction from a field ("+strings.TrimSpace(match)+") not supported")
+		return
+	}
+
+	regex, notes, err := convertPCRE(value)
+	if err != nil {
+		splunkImport.skip(sourcetype, key, err.Error())
+		return
+	}
+
+	if !strings.Contains(regex, "(?P<") {
+		splunkImport.skip(sourcetype, key, "no named capture groups")
+		return
+	}
+
+	splunkImport.add(sourcetype, "extract", class, Attributes{"regex": regex})
+	splunkImport.convert(sourcetype, key, notes)
+}
+
+// Function to import a transforms.conf stanza referenced by REPORT-<class>, as extraction or delims
+func (splunkImport *splunkImport) importReport(sourcetype string, key string, name string) {
+
+	key = key + " " + name
+
+	transform, ok := splunkImport.Transforms[name]
+	if !ok {
+		splunkImport.skip(sourcetype, key, "transform not found in transforms.conf")
+		return
+	}
+
+	if sourceKey, ok := transform["SOURCE_KEY"]; ok && sourceKey != "_raw" {
+		splunkImport.skip(sourcetype, key, "SOURCE_KEY "+sourceKey+" not supported")
+		return
+	}
+
+	if delims, ok := transform["DELIMS"]; ok {
+		splunkImport.importDelims(sourcetype, key, name, delims, transform["FIELDS"])
+		return
+	}
+
+	regex, notes, err := convertPCRE(transform["REGEX"])
+	if err != nil || transform["REGEX"] == "" {
+		if err == nil {
+			err = fmt.Errorf("REGEX missing")
+		}
+		splunkImport.skip(sourcetype, key, err.Error())
+		return
+	}
+
+	// FORMAT = field::$1 field2::$2 names the numbered groups
+	if format := strings.TrimSpace(transform["FORMAT"]); format != "" {
+		names := map[int]string{}
+		for _, field := range strings.Fields(format) {
+			match := formatFieldRegex.FindStringSubmatch(field)
+			if match == nil {
+				splunkImport.skip(sourcetype, key, "FORMAT "+field+" not supported, only field::$n")
+				return
+			}
+			number, _ := strconv.Atoi(match[2])
+			names[number] = match[1]
+		}
+		if regex, err = nameGroups(regex, names); err != nil {
+			splunkImport.skip(sourcetype, key, err.Error())
+			return
+		}
+	}
+
+	if !strings.Contains(regex, "(?P<") {
+		splunkImport.skip(sourcetype, key, "no named capture groups and no FORMAT")
+		return
+	}
+
+	attributes := Attributes{"regex": regex}
+
+	// MV_ADD keeps every match as multi value field
+	if strings.ToLower(transform["MV_ADD"]) == "true" {
+		attributes["multimatch"] = true
+	}
+
+	splunkImport.add(sourcetype, "extract", name, attributes)
+	splunkImport.convert(sourcetype, key, notes)
+}
+
+// Function to import DELIMS and FIELDS of a transform
+func (splunkImport *splunkImport) importDelims(sourcetype string, key string, name string, delims string, fields string) {
+
+	delimList := splitConfList(delims)
+
+	if len(delimList) != 1 {
+		splunkImport.skip(sourcetype, key, "DELIMS with pair and key value delimiters not supported")
+		return
+	}
+
+	delim := delimList[0]
+	if len(strings.Replace(delim, `\t`, "\t", -1)) != 1 {
+		splunkImport.skip(sourcetype, key, "DELIMS with more than one delimiter character not supported")
+		return
+	}
+
+	fieldList := splitConfList(fields)
+	if len(fieldList) == 0 {
+		splunkImport.skip(sourcetype, key, "DELIMS without FIELDS not supported")
+		return
+	}
+
+	splunkImport.add(sourcetype, "delims", name, Attributes{"delim": delim, "fields": strings.Join(fieldList, ",")})
+	splunkImport.convert(sourcetype, key, nil)
+}
+
+// Function to import an index time transform, as routing transform or filter
+func (splunkImport *splunkImport) importTransform(sourcetype string, key string, class string, name string) {
+
+	key = key + " " + name
+
+	transform, ok := splunkImport.Transforms[name]
+	if !ok {
+		splunkImport.skip(sourcetype, key, "transform not found in transforms.conf")
+		return
+	}
+
+	if sourceKey, ok := transform["SOURCE_KEY"]; ok && sourceKey != "_raw" {
+		splunkImport.skip(sourcetype, key, "SOURCE_KEY "+sourceKey+" not supported")
+		return
+	}
+
+	regex, notes, err := convertPCRE(transform["REGEX"])
+	if err != nil || transform["REGEX"] == "" {
+		if err == nil {
+			err = fmt.Errorf("REGEX missing")
+		}
+		splunkImport.skip(sourcetype, key, err.Error())
+		return
+	}
+
+	format := transform["FORMAT"]
+
+	destKey := ""
+	switch transform["DEST_KEY"] {
+	case "_MetaData:Index":
+		destKey = "index"
+	case "MetaData:Sourcetype":
+		destKey, format = "sourcetype", strings.TrimPrefix(format, "sourcetype::")
+	case "MetaData:Host":
+		destKey, format = "host", strings.TrimPrefix(format, "host::")
+	case "MetaData:Source":
+		destKey, format = "source", strings.TrimPrefix(format, "source::")
+	case "queue":
+		// Events sent to nullQueue are dropped, to indexQueue kept
+		switch format {
+		case "nullQueue":
+			splunkImport.add(sourcetype, "filter", class, Attributes{"regex": regex, "action": "exclude"})
+		case "indexQueue":
+			splunkImport.add(sourcetype, "filter", class, Attributes{"regex": regex, "action": "include"})
+			notes = append(notes, "indexQueue imported as include filter, events not matching any include filter are dropped")
+		default:
+			splunkImport.skip(sourcetype, key, "queue "+format+" not supported")
+			return
+		}
+		splunkImport.convert(sourcetype, key, notes)
+		return
+	default:
+		splunkImport.skip(sourcetype, key, "DEST_KEY "+transform["DEST_KEY"]+" not supported")
+		return
+	}
+
+	// $1 is written as ${1}, so following letters are not taken as part of the group name
+	format = formatGroupRegex.ReplaceAllString(format, "$${$1}")
+
+	splunkImport.add(sourcetype, "transforms", class, Attributes{"regex": regex, "destkey": destKey, "format": format})
+	splunkImport.convert(sourcetype, key, notes)
+}
+
+// Function to import SEDCMD-<class> = s/<regex>/<replacement>/<flags> as redact rule
+func (splunkImport *splunkImport) importSedCmd(sourcetype string, key string, class string, value string) {
+
+	if len(value) < 2 || value[0] != 's' {
+		splunkImport.skip(sourcetype, key, "only s/<regex>/<replacement>/ is supported")
+		return
+	}
+
+	parts := splitSedCmd(value[2:], value[1])
+	if len(parts) != 3 {
+		splunkImport.skip(sourcetype, key, "expected s/<regex>/<replacement>/<flags>")
+		return
+	}
+
+	regex, notes, err := convertPCRE(parts[0])
+	if err != nil {
+		splunkImport.skip(sourcetype, key, err.Error())
+		return
+	}
+
+	switch parts[2] {
+	case "g":
+	case "":
+		notes = append(notes, "every match is replaced, not only the first")
+	default:
+		splunkImport.skip(sourcetype, key, "flags "+parts[2]+" not supported")
+		return
+	}
+
+	// \1 becomes ${1}, a literal $ has to be doubled
+	replacement := strings.Replace(parts[1], "$", "$$", -1)
+	replacement = sedGroupRegex.ReplaceAllString(replacement, "$${$1}")
+
+	splunkImport.add(sourcetype, "redact", class, Attributes{"type": "sed", "regex": regex, "replacement": replacement})
+	splunkImport.convert(sourcetype, key, notes)
+}
+
+// Function to import FIELDALIAS-<class> = <field> AS <alias> ..., one alias item per pair
+func (splunkImport *splunkImport) importFieldAlias(sourcetype string, key string, class string, value string) {
+
+	words := strings.Fields(value)
+	if len(words) == 0 || len(words)%3 != 0 {
+		splunkImport.skip(sourcetype, key, "expected <field> AS <alias> pairs")
+		return
+	}
+
+	pairs := len(words) / 3
+
+	for i := 0; i < pairs; i++ {
+		field, as, alias := words[i*3], strings.ToUpper(words[i*3+1]), words[i*3+2]
+		if as != "AS" && as != "ASNEW" {
+			splunkImport.skip(sourcetype, key, "expected <field> AS <alias> pairs")
+			return
+		}
+
+		aliasClass := class
+		if pairs > 1 {
+			aliasClass = fmt.Sprintf("%s-%d", class, i+1)
+		}
+		splunkImport.add(sourcetype, "alias", aliasClass, Attributes{"field": strings.Trim(field, `"`), "alias": strings.Trim(alias, `"`)})
+	}
+
+	splunkImport.convert(sourcetype, key, nil)
+}
+
+// Function to split a comma separated list, items may be quoted to contain commas, e.g. DELIMS = ","
+func splitConfList(value string) []string {
+
+	var items []string
+	var item strings.Builder
+
+	quoted := false
+	for i := 0; i <= len(value); i++ {
+		switch {
+		case i < len(value) && value[i] == '"':
+			quoted = !quoted
+		case i < len(value) && (value[i] != ',' || quoted):
+			item.WriteByte(value[i])
+		default:
+			if trimmed := strings.TrimSpace(item.String()); trimmed != "" {
+				items = append(items, trimmed)
+			}
+			item.Reset()
+		}
+	}
+
+	return items
+}
+
+// Function to split the parts of a sed command at unescaped delimiters
+func splitSedCmd(value string, delim byte) []string {
+
+	var parts []string
+	var part strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] == delim:
+			part.WriteByte(delim)
+			i++
+		case value[i] == '\\' && i+1 < len(value):
+			part.WriteString(value[i : i+2])
+			i++
+		case value[i] == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(value[i])
+		}
+	}
+
+	return append(parts, part.String())
+}
+
+// Function to suggest the linebreaker of the tcp input for a LINE_BREAKER
+func lineBreakerHint(value string) string {
+
+	reason := "line breaking is configured per input, see input.linebreaker of the pipeline config"
+
+	// ([\r\n]+)(?=<start>) breaks before lines matching <start>
+	for _, breaker := range []string{`([\r\n]+)`, `([\n\r]+)`, `(\n+)`, `([\r\n])`, `(\n)`} {
+		if !strings.HasPrefix(value, breaker) {
+			continue
+		}
+		rest := strings.TrimPrefix(value, breaker)
+		if rest == "" {
+			return reason + ", one event per line is the default"
+		}
+		if strings.HasPrefix(rest, "(?=") && strings.HasSuffix(rest, ")") {
+			rest = rest[3 : len(rest)-1]
+		}
+		if linebreaker, _, err := convertPCRE(rest); err == nil {
+			return reason + ", e.g. linebreaker: '^" + linebreaker + "'"
+		}
+	}
+
+	return reason
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testProps = `# comment
+LINE_BREAKER = ([\r\n]+)
+
+[cisco:asa]
+EXTRACT-conn = (?<action>Built|Teardown) (?<direction>inbound|outbound)
+EXTRACT-field = (?<user>\w+) in message
+EXTRACT-lookahead = (?<x>\d+)(?=ms)
+REPORT-kv = asa_kv, missing_transform
+TRANSFORMS-route = asa_index, asa_null
+SEDCMD-mask = s/password=\S+/password=****/g
+FIELDALIAS-src = src_ip AS src dest_ip ASNEW dest
+TIME_FORMAT = %b %d %H:%M:%S
+
+[cisco:asa]
+REPORT-csv = asa_csv
+
+[source::/var/log/messages]
+EXTRACT-x = (?<x>\w+)
+
+[long:line]
+EXTRACT-multi = (?<a>\w+)\
+=(?<b>\w+)
+`
+
+const testTransforms = `[asa_kv]
+REGEX = (\w+)=(\d+)
+FORMAT = key::$1 value::$2
+MV_ADD = true
+
+[asa_csv]
+DELIMS = ","
+FIELDS = "time","host",,"action"
+
+[asa_index]
+REGEX = %ASA-[0-3]-
+DEST_KEY = _MetaData:Index
+FORMAT = critical_$1x
+
+[asa_null]
+REGEX = %ASA-7-
+DEST_KEY = queue
+FORMAT = nullQueue
+`
+
+// Function to import the test props.conf and transforms.conf
+func testSplunkImport(t *testing.T) *splunkImport {
+
+	dir, err := ioutil.TempDir("", "splunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	propsPath := filepath.Join(dir, "props.conf")
+	transformsPath := filepath.Join(dir, "transforms.conf")
+
+	if err = ioutil.WriteFile(propsPath, []byte(testProps), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(transformsPath, []byte(testTransforms), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	props, err := readConf(propsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transforms, err := readConf(transformsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	splunkImport := &splunkImport{
+		Transforms: map[string]map[string]string{},
+		Document:   &ConfigDocument{Version: 1, Props: map[string]map[string]map[string]Attributes{}},
+	}
+
+	for _, stanza := range transforms {
+		settings := map[string]string{}
+		for _, setting := range stanza.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		splunkImport.Transforms[stanza.Name] = settings
+	}
+
+	for _, stanza := range props {
+		splunkImport.importStanza(stanza)
+	}
+
+	return splunkImport
+}
+
+func TestReadConf(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "splunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "props.conf")
+	if err = ioutil.WriteFile(path, []byte(testProps), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stanzas, err := readConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, stanza := range stanzas {
+		names = append(names, stanza.Name)
+	}
+
+	// Stanzas given twice are merged, settings before the first stanza belong to default
+	if want := []string{"default", "cisco:asa", "source::/var/log/messages", "long:line"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("stanzas %q, want %q", names, want)
+	}
+
+	if len(stanzas[1].Settings) != 9 {
+		t.Errorf("%d settings of cisco:asa, want 9", len(stanzas[1].Settings))
+	}
+
+	if continued := stanzas[3].Settings[0].Value; continued != "(?<a>\\w+)\n=(?<b>\\w+)" {
+		t.Errorf("continued setting %q, want both lines", continued)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("[a]\nno value\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readConf(path); err == nil {
+		t.Error("no error for a line without =")
+	}
+}
+
+func TestSplunkImport(t *testing.T) {
+
+	splunkImport := testSplunkImport(t)
+
+	want := map[string]map[string]Attributes{
+		"extract": {
+			"conn":   {"regex": `(?P<action>Built|Teardown) (?P<direction>inbound|outbound)`},
+			"asa_kv": {"regex": `(?P<key>\w+)=(?P<value>\d+)`, "multimatch": true},
+		},
+		"delims": {
+			"asa_csv": {"delim": ",", "fields": "time,host,action"},
+		},
+		"transforms": {
+			"route-00-asa_index": {"regex": `%ASA-[0-3]-`, "destkey": "index", "format": "critical_${1}x"},
+		},
+		"filter": {
+			"route-01-asa_null": {"regex": `%ASA-7-`, "action": "exclude"},
+		},
+		"redact": {
+			"mask": {"type": "sed", "regex": `password=\S+`, "replacement": "password=****"},
+		},
+		"alias": {
+			"src-1": {"field": "src_ip", "alias": "src"},
+			"src-2": {"field": "dest_ip", "alias": "dest"},
+		},
+	}
+
+	if got := splunkImport.Document.Props["cisco:asa"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("cisco:asa imported as\n%v\nwant\n%v", got, want)
+	}
+
+	if got := splunkImport.Document.Props["long:line"]["extract"]["multi"]["regex"]; got != "(?P<a>\\w+)\n=(?P<b>\\w+)" {
+		t.Errorf("continued extraction %q, want both lines", got)
+	}
+
+	if _, ok := splunkImport.Document.Props["source::/var/log/messages"]; ok {
+		t.Error("source:: stanza imported")
+	}
+
+	// The extraction from a field, the lookahead, the missing transform, TIME_FORMAT, the source:: stanza and
+	// LINE_BREAKER of default
+	if splunkImport.skipped != 6 {
+		t.Errorf("%d settings skipped, want 6", splunkImport.skipped)
+	}
+}
+
+func TestSplitConfList(t *testing.T) {
+
+	tests := map[string][]string{
+		`a, b ,c`:              {"a", "b", "c"},
+		`","`:                  {","},
+		`"time","host",,"act"`: {"time", "host", "act"},
+		``:                     nil,
+	}
+
+	for value, want := range tests {
+		if got := splitConfList(value); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitConfList(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestSplitSedCmd(t *testing.T) {
+
+	tests := []struct {
+		value string
+		delim byte
+		want  []string
+	}{
+		{`a/b/g`, '/', []string{"a", "b", "g"}},
+		{`\/path\/x/y/`, '/', []string{"/path/x", "y", ""}},
+		{`\d+#\1#g`, '#', []string{`\d+`, `\1`, "g"}},
+	}
+
+	for _, test := range tests {
+		if got := splitSedCmd(test.value, test.delim); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitSedCmd(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
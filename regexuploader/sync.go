@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Function to read a directory of <sourcetype>.json files into a document
+//
+// A file either maps class names to regexes, like fieldextractor2/testdata/conf/mysourcetype.json, or sections to
+// classes, where a class is a regex or an object of attributes:
+//
+//	{"src_ip,dst_ip": "src (?P<src_ip>\\S+) dst (?P<dst_ip>\\S+)"}
+//	{"extract": {"proto": {"regex": "...", "multimatch": true}}, "transforms": {"asa_conn": {...}}}
+func readSyncDir(dir string) (*ConfigDocument, error) {
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: no <sourcetype>.json files found", dir)
+	}
+
+	document := &ConfigDocument{Version: 1, Props: map[string]map[string]map[string]Attributes{}}
+
+	for _, path := range paths {
+
+		// Sourcetypes may be escaped in file names, e.g. cisco%3Aasa.json
+		sourcetype, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		if _, ok := document.Props[sourcetype]; ok {
+			return nil, fmt.Errorf("%s: sourcetype %s defined twice", path, sourcetype)
+		}
+
+		sections, err := readSyncFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		document.Props[sourcetype] = sections
+	}
+
+	return document, nil
+}
+
+// Function to read the sections of one sourcetype file
+func readSyncFile(path string) (map[string]map[string]Attributes, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var content map[string]json.RawMessage
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// Plain class to regex maps hold extractions
+	plain := true
+	for _, value := range content {
+		var regex string
+		if json.Unmarshal(value, &regex) != nil {
+			plain = false
+		}
+	}
+
+	if plain {
+		classes, err := readSyncClasses(path, "extract", content)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]map[string]Attributes{"extract": classes}, nil
+	}
+
+	sections := map[string]map[string]Attributes{}
+
+	for section, value := range content {
+
+		if section == "routes" {
+			section = "transforms"
+		}
+
+		if !isPropsSection(section) {
+			return nil, fmt.Errorf("%s: unknown section %s", path, section)
+		}
+
+		var classContent map[string]json.RawMessage
+		if err := json.Unmarshal(value, &classContent); err != nil {
+			return nil, fmt.Errorf("%s: section %s: %v", path, section, err)
+		}
+
+		classes, err := readSyncClasses(path, section, classContent)
+		if err != nil {
+			return nil, err
+		}
+
+		sections[section] = classes
+	}
+
+	return sections, nil
+}
+
+// Function to read the classes of a section, a class is a regex or an object of attributes
+func readSyncClasses(path string, section string, content map[string]json.RawMessage) (map[string]Attributes, error) {
+
+	classes := map[string]Attributes{}
+
+	for class, value := range content {
+
+		if class == "" || strings.Contains(class, "/") {
+			return nil, fmt.Errorf("%s: %s class %q: names must not be empty or contain /", path, section, class)
+		}
+
+		var regex string
+		if json.Unmarshal(value, &regex) == nil {
+			classes[class] = Attributes{"regex": regex}
+			continue
+		}
+
+		var attributes Attributes
+		if err := json.Unmarshal(value, &attributes); err != nil {
+			return nil, fmt.Errorf("%s: %s class %s: %v", path, section, class, err)
+		}
+
+		classes[class] = attributes
+	}
+
+	return classes, nil
+}
+
+// Function to make the sections of the directory's sourcetypes match their files
+func syncCommand(v3ioClient *V3IOClient, dryRun bool, prune bool, samples map[string][]string, args []string) error {
+
+	if len(args) != 1 {
+		return fmt.Errorf("sync takes a directory")
+	}
+
+	desired, err := readSyncDir(args[0])
+	if err != nil {
+		return err
+	}
+
+	live, err := exportDocument(v3ioClient, "")
+	if err != nil {
+		return err
+	}
+
+	// Only outputs, lookups and networks are left alone
+	live.Outputs, live.Lookups, live.Networks = nil, nil, nil
+
+	dirs := coveredDirs(desired)
+
+	// Sourcetypes without file are removed completely
+	if prune {
+		for sourcetype, sections := range live.Props {
+			if _, ok := desired.Props[sourcetype]; ok {
+				continue
+			}
+			for section := range sections {
+				dirs["conf/props/"+sourcetype+"/"+section+"/"] = true
+			}
+		}
+	}
+
+	liveItems := flattenDocument(live)
+
+	changes := diffItems(liveItems, flattenDocument(desired), dirs)
+
+	// All items are checked before anything is written
+	if err := checkChanges(liveItems, changes, samples); err != nil {
+		return err
+	}
+
+	// Writes go first, so a failed sync never leaves a sourcetype with less than before
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Op != "delete" && changes[j].Op == "delete"
+	})
+
+	if dryRun {
+		printChanges(changes)
+		return nil
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("Nothing to do")
+		return nil
+	}
+
+	return applyChanges(v3ioClient, changes)
+}
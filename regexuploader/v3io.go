@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// V3IOClient Struct, talks to the v3io web API the functions read their config from
+type V3IOClient struct {
+	Endpoint  string
+	Container string
+
+	client *http.Client
+}
+
+// Attributes of a KV item, values are strings, numbers (float64) or bools
+type Attributes map[string]interface{}
+
+// ListBucketResult Struct
+type ListBucketResult struct {
+	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// CommonPrefix Struct
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// getItemsResult Struct
+type getItemsResult struct {
+	LastItemIncluded string                              `json:"LastItemIncluded"`
+	NextMarker       string                              `json:"NextMarker"`
+	Items            []map[string]map[string]interface{} `json:"Items"`
+}
+
+// NewV3IOClient creates a client for container at endpoint, e.g. http://10.90.1.171:8081 and splunk
+func NewV3IOClient(endpoint string, container string) *V3IOClient {
+	return &V3IOClient{Endpoint: endpoint, Container: container, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Function to build the URL of a path, escaping every segment
+func (v3ioClient *V3IOClient) url(path string) string {
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	result := strings.TrimRight(v3ioClient.Endpoint, "/") + "/" + v3ioClient.Container + "/" + strings.Join(segments, "/")
+	if strings.HasSuffix(path, "/") {
+		result += "/"
+	}
+
+	return result
+}
+
+// Function to send a request, returns the response body and status code
+func (v3ioClient *V3IOClient) request(method string, path string, function string, body interface{}) ([]byte, int, error) {
+
+	var reqBody []byte
+	if body != nil {
+		reqBody, _ = json.Marshal(body)
+	}
+
+	req, err := http.NewRequest(method, v3ioClient.url(path), bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if function != "" {
+		req.Header.Set("X-v3io-function", function)
+	}
+
+	resp, err := v3ioClient.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return respBody, resp.StatusCode, fmt.Errorf("%s %s: %s: %s", function, path, resp.Status, respBody)
+	}
+
+	return respBody, resp.StatusCode, nil
+}
+
+// PutItem writes all attributes of the item at path
+func (v3ioClient *V3IOClient) PutItem(path string, attributes Attributes) error {
+
+	_, _, err := v3ioClient.request("POST", path, "PutItem", map[string]interface{}{"Item": encodeAttributes(attributes)})
+
+	return err
+}
+
+// GetItem reads the item at path, returns nil if it does not exist
+func (v3ioClient *V3IOClient) GetItem(path string) (Attributes, error) {
+
+	respBody, status, err := v3ioClient.request("POST", path, "GetItem", map[string]interface{}{"AttributesToGet": "*"})
+	if err != nil || status == http.StatusNotFound {
+		return nil, err
+	}
+
+	var result struct {
+		Item map[string]map[string]interface{} `json:"Item"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("GetItem %s: %v", path, err)
+	}
+
+	return decodeAttributes(result.Item), nil
+}
+
+// GetItems reads all items below dir by item name, following the markers
+func (v3ioClient *V3IOClient) GetItems(dir string) (map[string]Attributes, error) {
+
+	items := map[string]Attributes{}
+
+	marker := ""
+
+	for {
+		respBody, status, err := v3ioClient.request("POST", dir, "GetItems", map[string]interface{}{
+			"AttributesToGet": "__name,*",
+			"Limit":           1000,
+			"Marker":          marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Nothing configured below dir
+		if status == http.StatusNotFound {
+			return items, nil
+		}
+
+		var result getItemsResult
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			return nil, fmt.Errorf("GetItems %s: %v", dir, err)
+		}
+
+		for _, item := range result.Items {
+			attributes := decodeAttributes(item)
+
+			name, _ := attributes["__name"].(string)
+			delete(attributes, "__name")
+
+			items[name] = attributes
+		}
+
+		if result.LastItemIncluded != "FALSE" || result.NextMarker == "" {
+			return items, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// DeleteItem removes the item at path, deleting a missing item is no error
+func (v3ioClient *V3IOClient) DeleteItem(path string) error {
+
+	_, _, err := v3ioClient.request("DELETE", path, "", nil)
+
+	return err
+}
+
+// ListDirs returns the names of the directories below dir
+func (v3ioClient *V3IOClient) ListDirs(dir string) ([]string, error) {
+
+	respBody, status, err := v3ioClient.request("GET", dir, "", nil)
+	if err != nil || status == http.StatusNotFound {
+		return nil, err
+	}
+
+	var listBucketResult ListBucketResult
+	if err := xml.Unmarshal(respBody, &listBucketResult); err != nil {
+		return nil, fmt.Errorf("ListBucket %s: %v", dir, err)
+	}
+
+	var dirs []string
+	for _, commonPrefix := range listBucketResult.CommonPrefixes {
+		name := strings.TrimSuffix(commonPrefix.Prefix, "/")
+		dirs = append(dirs, name[strings.LastIndex(name, "/")+1:])
+	}
+
+	return dirs, nil
+}
+
+// Function to encode attributes into v3io's typed JSON
+func encodeAttributes(attributes Attributes) map[string]map[string]interface{} {
+
+	encoded := map[string]map[string]interface{}{}
+
+	for name, value := range attributes {
+		switch value := value.(type) {
+		case bool:
+			encoded[name] = map[string]interface{}{"BOOL": value}
+		case float64:
+			encoded[name] = map[string]interface{}{"N": strconv.FormatFloat(value, 'f', -1, 64)}
+		case int:
+			encoded[name] = map[string]interface{}{"N": strconv.Itoa(value)}
+		default:
+			encoded[name] = map[string]interface{}{"S": fmt.Sprint(value)}
+		}
+	}
+
+	return encoded
+}
+
+// Function to decode v3io's typed JSON, system attributes but __name are dropped
+func decodeAttributes(item map[string]map[string]interface{}) Attributes {
+
+	attributes := Attributes{}
+
+	for name, typed := range item {
+
+		if strings.HasPrefix(name, "__") && name != "__name" {
+			continue
+		}
+
+		if value, ok := typed["S"]; ok {
+			attributes[name] = fmt.Sprint(value)
+		} else if value, ok := typed["N"]; ok {
+			number, _ := strconv.ParseFloat(fmt.Sprint(value), 64)
+			attributes[name] = number
+		} else if value, ok := typed["BOOL"]; ok {
+			attributes[name] = value == true
+		}
+	}
+
+	return attributes
+}
@@ -1,121 +1,41 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
-	"net"
 	"os"
-	"regexp"
-	"time"
-)
-
-func handleConnection(conn net.Conn) {
-	fmt.Println("Handling new connection...")
-
-	fmt.Println(conn.RemoteAddr())
-
-	// Close connection when this function ends
-	defer func() {
-		fmt.Println("Closing connection...")
-		conn.Close()
-	}()
-
-	// Set timeout to 5 seconds
-	timeoutDuration := 30 * time.Second
-
-	// Create new buffered reader
-	bufReader := bufio.NewReader(conn)
-
-	// Create linescanner
-	scanner := bufio.NewScanner(bufReader)
-
-	// Currently statical regexPattern. Should be set automatically according to sourcetype
-	regexPattern := `^\d{4}-\d{2}-\d{2}`
-
-	// Regex do find beginning of new event, usefull for multiline events
-	re := regexp.MustCompile(regexPattern)
-
-	var event string
-
-	f, _ := os.Create("/tmp/event")
-	defer f.Close()
-
-	// Loop over Lines
-	for scanner.Scan() {
-
-		// Search for new events
-		match := re.FindStringSubmatch(scanner.Text())
-		if match != nil { // New event starts
-			if event != "" {
-				// If we have already collected an event, we should persist it somwhere
-				print("\nEvent:\n", event)
-				f.WriteString(event)
-				f.Sync()
-			}
-			// Store first line of new event
-			event = scanner.Text()
-		} else {
-			// Append next line to event
-			event = event + "\n" + scanner.Text()
-		}
-
-		// Error handling
-		if err := scanner.Err(); err != nil {
-			println("Error:", err)
-		}
 
-		// Reset timeout before looping
-		conn.SetReadDeadline(time.Now().Add(timeoutDuration))
-
-	}
-	// After the timeout, we should also persist
-	print("\nEvent:\n", event)
-	f.WriteString(event)
-	f.Sync()
+	"github.com/my2ndhead/nuclio_event_etl/pipeline"
+)
 
-}
+// Pipeline used unless PIPELINE_CONFIG names a file, the redact rules are read from CONFIG_SOURCE
+const defaultPipelineConfig = `
+version: 1
+name: tcpinput
+input:
+  type: tcp
+  address: 0.0.0.0:12000
+  timeout: 30s
+  # Currently statical line breaker. Should be set automatically according to sourcetype
+  linebreaker: '^\d{4}-\d{2}-\d{2}'
+stages:
+  # Masking with the redact rules read from CONFIG_SOURCE
+  - type: redact
+outputs:
+  - type: file
+    path: /tmp/event
+`
 
 func main() {
 
-	// Make Bindadress configurable
-	bindAddr := os.Getenv("TCPINPUT_BINDADDR")
-
-	// Make Port configurable
-	port := os.Getenv("TCPINPUT_PORT")
-
-	// Define default Bindadress
-	if bindAddr == "" {
-		bindAddr = "0.0.0.0"
-	}
+	logger := pipeline.StdLogger{}
 
-	//Define default port
-	if port == "" {
-		port = "12000"
-	}
-
-	// Create listener
-	listener, err := net.Listen("tcp", bindAddr+":"+port)
+	input, err := pipeline.NewTCPInputFromConfig(defaultPipelineConfig, logger)
 	if err != nil {
-		fmt.Println(err)
-		return
+		logger.ErrorWith("Pipeline config error", "err", err)
+		os.Exit(1)
 	}
 
-	defer func() {
-		listener.Close()
-		fmt.Println("Listener closed")
-	}()
-
-	for {
-		// Get net.TCPConn object
-		conn, err := listener.Accept()
-
-		if err != nil {
-			fmt.Println(err)
-			break
-		}
-
-		// Run the connection handler
-		go handleConnection(conn)
+	if err := input.ListenAndServe(); err != nil {
+		logger.ErrorWith("Listener error", "err", err)
+		os.Exit(1)
 	}
-
 }